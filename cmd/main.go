@@ -1,130 +1,193 @@
 package main
 
 import (
-	"encoding/base64"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"net"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
-	"time"
 
 	"github.com/eglochon/simple-lan-messaging/config"
-	"github.com/eglochon/simple-lan-messaging/models"
 	"github.com/eglochon/simple-lan-messaging/pkg/comms"
-	"github.com/eglochon/simple-lan-messaging/pkg/discovery"
+	"github.com/eglochon/simple-lan-messaging/pkg/contacts"
+	"github.com/eglochon/simple-lan-messaging/pkg/control"
+	"github.com/eglochon/simple-lan-messaging/pkg/history"
 	"github.com/eglochon/simple-lan-messaging/pkg/identity"
-	"google.golang.org/protobuf/proto"
+	"github.com/eglochon/simple-lan-messaging/pkg/node"
 )
 
 func main() {
-	config.Setup()
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	}
 
-	// Self Adress
-	selfAddr, err := discovery.NewSelfAddress()
+	// Load pinned contacts
+	contactStore, err := contacts.Open(contacts.DefaultPath())
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to get self address: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Failed to load contacts: %v\n", err)
 		os.Exit(1)
 	}
+	contactStore.Strict = cfg.ContactsStrict
 
-	// Load or generate identity
-	idPath := identity.DefaultPath()
-	id, err := identity.GetOrCreateIdentity(idPath)
+	// Open message history
+	historyStore, err := history.Open(history.DefaultPath())
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to load/generate identity: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Failed to open history: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Build discovery message
-	myID := id.GetID()
-	discMsg := &models.Discovery{
-		Id:   base64.RawURLEncoding.EncodeToString(id.SigningPublicKey),
-		Enc:  base64.RawURLEncoding.EncodeToString(id.EncryptPublicKey[:]),
-		Name: selfAddr.Hostname,
-		Ip:   selfAddr.IP,
-		Port: uint32(config.SERVICE_PORT),
+	// Open the outbox for offline peers
+	outbox, err := comms.OpenOutbox(comms.DefaultOutboxPath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open outbox: %v\n", err)
+		os.Exit(1)
 	}
 
-	msgBytes, err := proto.Marshal(discMsg)
+	// Load or generate the identity and set up the node
+	idPath := cfg.IdentityPath
+	if idPath == "" {
+		idPath = identity.DefaultPath()
+	}
+	n, err := node.New(node.Options{
+		IdentityPath:     idPath,
+		Passphrase:       passphraseSource(idPath, cfg.IdentityEncrypt),
+		Name:             cfg.Name,
+		Port:             cfg.ServicePort,
+		AnnounceInterval: cfg.AnnounceInterval,
+		Group:            cfg.AnnounceAddr,
+		Interface:        cfg.Interface,
+		Bind:             cfg.Bind,
+		MaxMessageSize:   cfg.MaxMessageSize,
+		Contacts:         contactStore,
+		Outbox:           outbox,
+		OutboxTTL:        cfg.OutboxTTL,
+		History:          historyStore,
+		Rekey: &comms.RekeyPolicy{
+			Messages: cfg.RekeyMessages,
+			Bytes:    cfg.RekeyBytes,
+			Interval: cfg.RekeyInterval,
+		},
+		Keepalive: &comms.KeepalivePolicy{
+			Interval: cfg.KeepaliveInterval,
+			Timeout:  cfg.KeepaliveTimeout,
+		},
+		Reconnect: &comms.ReconnectPolicy{
+			MinDelay:    cfg.ReconnectMinDelay,
+			MaxDelay:    cfg.ReconnectMaxDelay,
+			DialTimeout: cfg.DialTimeout,
+		},
+		Presence: &comms.PresencePolicy{
+			IdleAfter:    cfg.PresenceIdle,
+			OfflineAfter: cfg.PresenceOffline,
+			EvictAfter:   cfg.PeerTTL,
+		},
+	})
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to encode discovery message: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Failed to set up node: %v\n", err)
 		os.Exit(1)
 	}
+	id, selfAddr, peerManager := n.Identity(), n.SelfAddress(), n.PeerManager()
 
-	fmt.Printf("Client ID: (%s) [%s] %s\n", selfAddr.Hostname, selfAddr.IP, myID)
-
-	// Create a PeerManager
-	peerManager := comms.NewPeerManager(id)
-	peerManager.OnMessage(func(peerID string, env *models.Envelope) {
-		fmt.Printf("[MESSAGE RECEIVED] From %s\n", peerID)
-		switch payload := env.Payload.(type) {
-		case *models.Envelope_Peers:
-			fmt.Println("Peers:", payload.Peers.Id, payload.Peers.Name)
-		case *models.Envelope_Message:
-			fmt.Println("Message:", payload.Message.Topic, payload.Message.Content)
-		default:
-			fmt.Println("Unknown payload type")
-		}
-	})
+	fmt.Printf("Client ID: (%s) [%s] %s\n", selfAddr.Hostname, selfAddr.IP, id.GetID())
 
+	chat := newRepl(peerManager, contactStore, historyStore, outbox, id, selfAddr, os.Stdout)
+	chat.readReceipts = cfg.ReadReceipts
+	chat.outboxTTL = cfg.OutboxTTL
+	chat.downloadDir = cfg.DownloadDir
+
+	// Serve the local control API
+	socketPath := cfg.ControlSocket
+	if socketPath == "" {
+		socketPath = control.DefaultPath()
+	}
+	controlServer := control.NewServer(socketPath, peerManager, id, n.Name())
+	controlServer.OutboxTTL = cfg.OutboxTTL
+	if err := controlServer.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to start control API: %v\n", err)
+		os.Exit(1)
+	}
+
+	peerManager.OnReceive(controlServer.Deliver)
+	peerManager.OnOutboxSent(chat.PrintOutboxSent)
+	peerManager.OnFileOffer(chat.PrintFileOffer)
+	peerManager.OnFileProgress(chat.PrintFileProgress)
+	var consumers sync.WaitGroup
+	consumers.Add(2)
+	go func() {
+		defer consumers.Done()
+		for msg := range n.Messages() {
+			chat.PrintMessage(msg.PeerID, msg.Envelope)
+		}
+	}()
 	go func() {
-		for {
-			time.Sleep(5 * time.Second)
-			peers := peerManager.AllPeers()
-
-			for _, peer := range peers {
-				// Skip if peer is self
-				if peer.ID == myID {
-					continue
-				}
-				msg := fmt.Sprintf("Hello from %s at %s", selfAddr.Hostname, time.Now().Format("15:04:05"))
-				err := peerManager.Send(peer.ID, &models.Envelope{
-					Type: "message",
-					Payload: &models.Envelope_Message{
-						Message: &models.TopicMessage{Topic: "hello", Content: msg},
-					},
-				})
-				if err != nil {
-					fmt.Printf("[SEND ERROR] To %s: %v\n", peer.ID, err)
-				} else {
-					fmt.Printf("[MESSAGE SENT] To %s: %s\n", peer.ID, msg)
-				}
+		defer consumers.Done()
+		for event := range n.Events() {
+			switch event.Type {
+			case node.EventReceipt:
+				chat.PrintReceipt(event.PeerID, event.Receipt)
+				controlServer.DeliverReceipt(event.PeerID, event.Receipt)
+			case node.EventPeerJoined:
+				chat.PrintPeerJoined(event.PeerID)
+			case node.EventPeerLeft:
+				chat.PrintPeerLeft(event.PeerID)
+			case node.EventConnState:
+				chat.PrintConnState(event.PeerID, event.State)
+			case node.EventSecurity:
+				chat.PrintSecurityWarning(event.PeerID, event.Err)
 			}
 		}
 	}()
 
-	serviceAddr := selfAddr.Addr(config.SERVICE_PORT)
-	receiver := comms.NewTCPReceiver(serviceAddr, id, peerManager)
-	receiver.Start()
-	fmt.Println("Receiver started")
-
-	// Start discovery service
-	discoveryService, err := discovery.NewDiscoveryService(msgBytes, config.ANNOUNCE_INTERVAL, func(data []byte, addr *net.UDPAddr) {
-		var msg models.Discovery
-		if err := proto.Unmarshal(data, &msg); err == nil {
-			if err := peerManager.RegisterDiscovery(&msg, addr); err != nil {
-				fmt.Printf("[REGISTER ERROR] from %s: %v\n", addr.IP, err)
-			} else {
-				fmt.Printf("[DISCOVERED] ID: %s, Name: %s, IP: %s, Port: %d\n", msg.Id, msg.Name, addr.IP, msg.Port)
-			}
-		} else {
-			fmt.Printf("[INVALID MESSAGE] from %s: %v\n", addr.IP, err)
+	// Stopping the node closes the channels the consumers read. The history
+	// is closed last, once nothing can write to it anymore.
+	shutdown := func() error {
+		err := errors.Join(controlServer.Stop(), n.Stop())
+		consumers.Wait()
+		return errors.Join(err, historyStore.Close())
+	}
+
+	// Everything stops on an interrupt, or when the chat ends
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := n.Start(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to start node: %v\n", err)
+		shutdown()
+		os.Exit(1)
+	}
+
+	// Stay connected to the contacts we vouched for
+	for _, c := range contactStore.All() {
+		if c.Trust == contacts.Verified || c.Trust == contacts.Trusted {
+			peerManager.Supervise(c.ID)
 		}
-	})
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to start discovery service: %v\n", err)
+	}
+	fmt.Println("Node started. Press Ctrl+C or type /quit to stop.")
+
+	// Run the chat until /quit, EOF or an interrupt
+	done := make(chan struct{})
+	go func() {
+		chat.Run(os.Stdin)
+		close(done)
+	}()
+
+	select {
+	case <-ctx.Done():
+	case <-done:
+	}
+	stop()
+
+	fmt.Println("\nShutting down.")
+	if err := shutdown(); err != nil {
+		fmt.Fprintf(os.Stderr, "Shutdown failed: %v\n", err)
 		os.Exit(1)
 	}
-	discoveryService.Start()
-	fmt.Println("Discovery started. Press Ctrl+C to stop.")
-
-	// Wait for interrupt to gracefully shut down
-	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
-	<-sig
-
-	fmt.Println("\nShutting down discovery service.")
-	discoveryService.Stop()
-	receiver.Stop()
-	peerManager.Stop()
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/eglochon/simple-lan-messaging/pkg/identity"
+	"golang.org/x/term"
+)
+
+// passphraseSource returns how to unlock the identity at path, or nil if it
+// is a plaintext file and encryption wasn't requested
+func passphraseSource(path string, encrypt bool) identity.PassphraseFunc {
+	if !encrypt {
+		_, err := identity.LoadIdentity(path, nil)
+		if !errors.Is(err, identity.ErrPassphraseRequired) {
+			return nil
+		}
+	}
+	return promptPassphrase
+}
+
+// promptPassphrase reads the passphrase from IDENTITY_PASSPHRASE or the terminal
+func promptPassphrase(isNew bool) ([]byte, error) {
+	if pass, exists := os.LookupEnv("IDENTITY_PASSPHRASE"); exists {
+		return []byte(pass), nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, errors.New("no terminal to prompt for the identity passphrase, set IDENTITY_PASSPHRASE")
+	}
+
+	prompt := "Identity passphrase: "
+	if isNew {
+		prompt = "New identity passphrase: "
+	}
+	pass, err := readPassword(fd, prompt)
+	if err != nil {
+		return nil, err
+	}
+	if !isNew {
+		return pass, nil
+	}
+
+	if len(pass) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+	confirm, err := readPassword(fd, "Repeat passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(pass, confirm) {
+		return nil, errors.New("passphrases do not match")
+	}
+	return pass, nil
+}
+
+func readPassword(fd int, prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	defer fmt.Fprintln(os.Stderr)
+	return term.ReadPassword(fd)
+}
@@ -0,0 +1,710 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eglochon/simple-lan-messaging/models"
+	"github.com/eglochon/simple-lan-messaging/pkg/comms"
+	"github.com/eglochon/simple-lan-messaging/pkg/contacts"
+	"github.com/eglochon/simple-lan-messaging/pkg/discovery"
+	"github.com/eglochon/simple-lan-messaging/pkg/history"
+	"github.com/eglochon/simple-lan-messaging/pkg/identity"
+)
+
+// errQuit is returned by a command handler to end the REPL
+var errQuit = errors.New("quit")
+
+type command struct {
+	usage string
+	help  string
+	run   func(args string) error
+}
+
+// repl is the interactive line-based chat client
+type repl struct {
+	pm       *comms.PeerManager
+	contacts *contacts.Store
+	history  *history.Store
+	outbox   *comms.Outbox
+	self     *identity.Identity
+	selfAddr *discovery.SelfAddress
+	topic    string
+	out      io.Writer
+	commands map[string]command
+
+	readReceipts bool          // send read receipts for displayed messages
+	outboxTTL    time.Duration // expiry of messages queued for offline peers
+	downloadDir  string        // default destination of accepted files
+
+	mu       sync.Mutex
+	progress map[string]uint64       // transfer → last reported quarter
+	sent     map[string]*sentMessage // envelope ID → delivery status
+}
+
+// sentMessage tracks the receipts of a message sent in this session
+type sentMessage struct {
+	to     string // peer name, or "#topic"
+	status string
+}
+
+// statusRank orders delivery states so a late receipt never downgrades a message
+var statusRank = map[string]int{"queued": 0, "sent": 1, "delivered": 2, "read": 3}
+
+func newRepl(pm *comms.PeerManager, store *contacts.Store, hist *history.Store, outbox *comms.Outbox, self *identity.Identity, selfAddr *discovery.SelfAddress, out io.Writer) *repl {
+	r := &repl{
+		pm:       pm,
+		contacts: store,
+		history:  hist,
+		outbox:   outbox,
+		self:     self,
+		selfAddr: selfAddr,
+		topic:    "general",
+		out:      out,
+		progress: make(map[string]uint64),
+		sent:     make(map[string]*sentMessage),
+	}
+	if err := pm.Subscribe(r.topic, r.printTopic); err != nil {
+		fmt.Fprintf(out, "[ERROR] %v\n", err)
+	}
+	r.commands = map[string]command{
+		"/help":      {"/help", "show this help", r.cmdHelp},
+		"/peers":     {"/peers", "list known peers", r.cmdPeers},
+		"/msg":       {"/msg <peer> <text>", "send a direct message", r.cmdMsg},
+		"/join":      {"/join <topic>", "subscribe to a topic (patterns like team/* allowed)", r.cmdJoin},
+		"/leave":     {"/leave <topic>", "unsubscribe from a topic", r.cmdLeave},
+		"/topics":    {"/topics", "list subscribed topics", r.cmdTopics},
+		"/say":       {"/say <text>", "publish to the current topic (plain lines do the same)", r.cmdSay},
+		"/history":   {"/history [peer|#topic] [n]", "show the last n messages of a conversation", r.cmdHistory},
+		"/whois":     {"/whois <peer>", "show details about a peer", r.cmdWhois},
+		"/safety":    {"/safety <peer>", "show the safety number to compare with a peer", r.cmdSafety},
+		"/contacts":  {"/contacts", "list pinned contacts", r.cmdContacts},
+		"/rename":    {"/rename <peer> <name>", "change the name pinned for a contact", r.cmdRename},
+		"/verify":    {"/verify <peer>", "mark a contact's key as verified", r.setTrust("/verify", contacts.Verified)},
+		"/trust":     {"/trust <peer>", "mark a contact as trusted", r.setTrust("/trust", contacts.Trusted)},
+		"/block":     {"/block <peer>", "block a contact and drop its connection", r.setTrust("/block", contacts.Blocked)},
+		"/unblock":   {"/unblock <peer>", "reset a contact to unverified", r.setTrust("/unblock", contacts.Unverified)},
+		"/send-file": {"/send-file <peer> <path>", "offer a file to a peer", r.cmdSendFile},
+		"/accept":    {"/accept <id> [dir]", "accept a file offer", r.cmdAccept},
+		"/reject":    {"/reject <id>", "decline a file offer", r.cmdReject},
+		"/outbox":    {"/outbox", "list queued messages and their status", r.cmdOutbox},
+		"/transfers": {"/transfers", "list file transfers", r.cmdTransfers},
+		"/quit":      {"/quit", "leave the chat", r.cmdQuit},
+	}
+	return r
+}
+
+// Run reads commands from in until EOF or /quit
+func (r *repl) Run(in io.Reader) {
+	fmt.Fprintln(r.out, "Type /help for a list of commands.")
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if err := r.exec(line); err != nil {
+			if errors.Is(err, errQuit) {
+				return
+			}
+			fmt.Fprintf(r.out, "[ERROR] %v\n", err)
+		}
+	}
+}
+
+func (r *repl) exec(line string) error {
+	if !strings.HasPrefix(line, "/") {
+		return r.cmdSay(line)
+	}
+	name, args, _ := strings.Cut(line, " ")
+	cmd, ok := r.commands[name]
+	if !ok {
+		return fmt.Errorf("unknown command %s (try /help)", name)
+	}
+	return cmd.run(strings.TrimSpace(args))
+}
+
+// PrintMessage displays an incoming envelope
+func (r *repl) PrintMessage(peerID string, env *models.Envelope) {
+	from := r.peerLabel(peerID)
+	switch payload := env.Payload.(type) {
+	case *models.Envelope_Message:
+		if payload.Message.Topic == "" {
+			fmt.Fprintf(r.out, "[DM] <%s> %s\n", from, payload.Message.Content)
+			if r.readReceipts && env.Id != "" {
+				go r.markRead(peerID, env.Id)
+			}
+		} else {
+			fmt.Fprintf(r.out, "[#%s] <%s> %s\n", payload.Message.Topic, from, payload.Message.Content)
+		}
+	case *models.Envelope_Peers:
+		fmt.Fprintf(r.out, "[PEERS] from %s: %s %s\n", from, payload.Peers.Id, payload.Peers.Name)
+	default:
+		fmt.Fprintf(r.out, "[UNKNOWN] %q from %s\n", env.Type, from)
+	}
+}
+
+// PrintOutboxSent reports delivery of a queued message
+func (r *repl) PrintOutboxSent(entry comms.OutboxEntry) {
+	r.setStatus(entry.ID, "sent")
+	fmt.Fprintf(r.out, "[OUTBOX] Delivered queued message %s to %s\n", shortID(entry.ID), r.peerLabel(entry.PeerID))
+}
+
+// PrintConnState reports lost connections and those coming back
+func (r *repl) PrintConnState(peerID string, state comms.ConnState) {
+	switch state {
+	case comms.ConnConnected, comms.ConnDisconnected, comms.ConnReconnecting:
+		fmt.Fprintf(r.out, "[%s] %s\n", strings.ToUpper(string(state)), r.peerLabel(peerID))
+	}
+}
+
+func (r *repl) PrintPeerJoined(peerID string) {
+	fmt.Fprintf(r.out, "[ONLINE] %s\n", r.peerLabel(peerID))
+}
+
+func (r *repl) PrintPeerLeft(peerID string) {
+	fmt.Fprintf(r.out, "[OFFLINE] %s\n", r.peerLabel(peerID))
+}
+
+// PrintSecurityWarning reports a peer accepted despite a suspicious identity
+func (r *repl) PrintSecurityWarning(peerID string, err error) {
+	fmt.Fprintf(r.out, "[SECURITY WARNING] %v\n", err)
+	fmt.Fprintf(r.out, "  Check the key with /safety %s before trusting this peer\n", shortID(peerID))
+}
+
+// PrintReceipt updates the status of our messages and reports direct message receipts
+func (r *repl) PrintReceipt(peerID string, receipt *models.Receipt) {
+	status := "delivered"
+	if receipt.GetStatus() == models.ReceiptStatus_READ {
+		status = "read"
+	}
+	for _, id := range receipt.GetIds() {
+		msg, changed := r.setStatus(id, status)
+		if changed && !strings.HasPrefix(msg.to, "#") {
+			fmt.Fprintf(r.out, "[DM] %s %s by %s\n", shortID(id), status, r.peerLabel(peerID))
+		}
+	}
+}
+
+// PrintFileOffer announces an incoming file offer
+func (r *repl) PrintFileOffer(offer comms.FileTransfer) {
+	fmt.Fprintf(r.out, "[FILE] %s offers %s (%s), type /accept %s or /reject %s\n",
+		r.peerLabel(offer.PeerID), offer.Name, formatSize(offer.Size), offer.ID, offer.ID)
+}
+
+// PrintFileProgress reports transfer progress in quarter steps, and completion or failure
+func (r *repl) PrintFileProgress(p comms.FileTransfer) {
+	switch {
+	case p.Err != nil:
+		fmt.Fprintf(r.out, "[FILE] %s %s: %v\n", p.Direction, p.Name, p.Err)
+	case p.Done && p.Direction == comms.FileReceiving:
+		fmt.Fprintf(r.out, "[FILE] Received %s, saved to %s\n", p.Name, p.Path)
+	case p.Done:
+		fmt.Fprintf(r.out, "[FILE] %s delivered to %s\n", p.Name, r.peerLabel(p.PeerID))
+	case p.Size > 0:
+		quarter := p.Transferred * 4 / p.Size
+		r.mu.Lock()
+		key := p.PeerID + "/" + p.ID
+		changed := r.progress[key] != quarter
+		r.progress[key] = quarter
+		r.mu.Unlock()
+		if changed && quarter > 0 && quarter < 4 {
+			fmt.Fprintf(r.out, "[FILE] %s %s: %d%%\n", p.Direction, p.Name, quarter*25)
+		}
+	}
+}
+
+// printTopic displays a message received on a subscribed topic
+func (r *repl) printTopic(peerID string, msg *models.TopicMessage) {
+	fmt.Fprintf(r.out, "[#%s] <%s> %s\n", msg.Topic, r.peerLabel(peerID), msg.Content)
+}
+
+func (r *repl) cmdHelp(string) error {
+	names := make([]string, 0, len(r.commands))
+	for name := range r.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cmd := r.commands[name]
+		fmt.Fprintf(r.out, "  %-28s %s\n", cmd.usage, cmd.help)
+	}
+	return nil
+}
+
+func (r *repl) cmdPeers(string) error {
+	peers := r.otherPeers()
+	if len(peers) == 0 {
+		fmt.Fprintln(r.out, "No peers discovered yet.")
+		return nil
+	}
+	for _, peer := range peers {
+		status := string(peer.Presence)
+		if peer.IsConnected() {
+			status = "connected"
+		} else if status == "" {
+			status = string(comms.PresenceOffline)
+		}
+		fmt.Fprintf(r.out, "  %-20s %-10s %s  %s\n", displayName(peer), status, shortID(peer.ID), peer.Addr())
+	}
+	return nil
+}
+
+func (r *repl) cmdMsg(args string) error {
+	ref, text, _ := strings.Cut(args, " ")
+	text = strings.TrimSpace(text)
+	if ref == "" || text == "" {
+		return errors.New("usage: /msg <peer> <text>")
+	}
+	peerID, name, err := r.resolve(ref)
+	if err != nil {
+		return err
+	}
+	env := newMessage("", text)
+	id, status, err := r.pm.SendOrQueue(peerID, env, r.outboxTTL)
+	if err != nil {
+		return err
+	}
+	r.track(id, name, string(status))
+	if status == comms.OutboxQueued {
+		fmt.Fprintf(r.out, "[OUTBOX] %s is offline, message %s queued\n", name, shortID(id))
+	} else {
+		fmt.Fprintf(r.out, "[DM] %s sent to %s\n", shortID(id), name)
+	}
+	return nil
+}
+
+func (r *repl) cmdJoin(args string) error {
+	if args == "" || strings.ContainsAny(args, " \t") {
+		return errors.New("usage: /join <topic>")
+	}
+	if !slices.Contains(r.pm.Subscriptions(), args) {
+		if err := r.pm.Subscribe(args, r.printTopic); err != nil {
+			return err
+		}
+	}
+	if strings.ContainsAny(args, `*?[\`) {
+		fmt.Fprintf(r.out, "Listening on %s\n", args)
+		return nil
+	}
+	r.topic = args
+	fmt.Fprintf(r.out, "Now talking in #%s\n", r.topic)
+	return nil
+}
+
+func (r *repl) cmdLeave(args string) error {
+	if args == "" {
+		return errors.New("usage: /leave <topic>")
+	}
+	if !slices.Contains(r.pm.Subscriptions(), args) {
+		return fmt.Errorf("not subscribed to %s", args)
+	}
+	r.pm.Unsubscribe(args)
+	if args == r.topic {
+		r.topic = ""
+		fmt.Fprintf(r.out, "Left #%s, /join another topic to keep talking or /msg a peer\n", args)
+		return nil
+	}
+	fmt.Fprintf(r.out, "Left %s\n", args)
+	return nil
+}
+
+func (r *repl) cmdTopics(string) error {
+	for _, topic := range r.pm.Subscriptions() {
+		marker := " "
+		if topic == r.topic {
+			marker = "*"
+		}
+		fmt.Fprintf(r.out, " %s %s\n", marker, topic)
+	}
+	return nil
+}
+
+func (r *repl) cmdSay(args string) error {
+	if args == "" {
+		return errors.New("usage: /say <text>")
+	}
+	if r.topic == "" {
+		return errors.New("not in a topic, /join one or /msg a peer")
+	}
+	id, err := r.pm.Publish(r.topic, args)
+	if err != nil {
+		return err
+	}
+	r.track(id, "#"+r.topic, "sent")
+	return nil
+}
+
+func (r *repl) cmdHistory(args string) error {
+	fields := strings.Fields(args)
+	if len(fields) == 0 && r.topic == "" {
+		return errors.New("not in a topic, usage: /history [peer|#topic] [n]")
+	}
+	conversation := history.ConversationKey("", r.topic)
+	limit := 20
+	if len(fields) > 0 {
+		conversation = fields[0]
+		if !strings.HasPrefix(conversation, "#") {
+			peerID, _, err := r.resolve(conversation)
+			if err != nil {
+				return err
+			}
+			conversation = peerID
+		}
+	}
+	if len(fields) > 1 {
+		n, err := strconv.Atoi(fields[1])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid message count %q", fields[1])
+		}
+		limit = n
+	}
+
+	records, _, err := r.history.Page(conversation, -1, limit)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		fmt.Fprintln(r.out, "No messages yet.")
+		return nil
+	}
+	for _, rec := range records {
+		env, err := rec.Decode()
+		if err != nil {
+			continue
+		}
+		from, status := "me", ""
+		if rec.Direction == history.Inbound {
+			from = r.peerLabel(rec.PeerID)
+		} else if s := r.status(env.GetId()); s != "" {
+			status = " [" + s + "]"
+		}
+		fmt.Fprintf(r.out, "  %s <%s> %s%s\n", rec.Time.Format("2006-01-02 15:04"), from, env.GetMessage().GetContent(), status)
+	}
+	return nil
+}
+
+func (r *repl) cmdWhois(args string) error {
+	if args == "" {
+		return errors.New("usage: /whois <peer>")
+	}
+	peer, err := r.pm.Lookup(args)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(r.out, "  Name:      %s\n", displayName(peer))
+	fmt.Fprintf(r.out, "  ID:        %s\n", peer.ID)
+	fmt.Fprintf(r.out, "  Address:   %s\n", peer.Addr())
+	fmt.Fprintf(r.out, "  Connected: %t\n", peer.IsConnected())
+	if session := peer.Session; session != nil {
+		fmt.Fprintf(r.out, "  Protocol:  v%d, rekeyed %d times (sent) / %d times (received)\n", session.Version, session.Stats.RekeysSent, session.Stats.RekeysReceived)
+	}
+	if peer.RTT > 0 {
+		fmt.Fprintf(r.out, "  Latency:   %s\n", peer.RTT.Round(100*time.Microsecond))
+	}
+	if remote, err := identity.NewRemoteIdentity(peer.ID); err == nil {
+		fmt.Fprintf(r.out, "  Key:       %s\n", identity.FormatDigits(remote.Fingerprint()))
+	}
+	if c, ok := r.contacts.Get(peer.ID); ok {
+		fmt.Fprintf(r.out, "  Trust:     %s (pinned as %q since %s)\n", c.Trust, c.Name, c.FirstSeen.Format("2006-01-02"))
+	}
+	if !peer.LastSeen.IsZero() {
+		fmt.Fprintf(r.out, "  Last seen: %s\n", peer.LastSeen.Format("15:04:05"))
+	}
+	return nil
+}
+
+func (r *repl) cmdSafety(args string) error {
+	if args == "" {
+		return errors.New("usage: /safety <peer>")
+	}
+	peerID, name, err := r.resolve(args)
+	if err != nil {
+		return err
+	}
+	remote, err := identity.NewRemoteIdentity(peerID)
+	if err != nil {
+		return err
+	}
+	blocks := strings.Fields(identity.FormatDigits(r.self.SafetyNumber(remote)))
+	fmt.Fprintf(r.out, "Safety number with %s:\n\n", name)
+	for i := 0; i < len(blocks); i += 4 {
+		fmt.Fprintf(r.out, "    %s\n", strings.Join(blocks[i:min(i+4, len(blocks))], " "))
+	}
+	fmt.Fprintf(r.out, "\nRead it aloud with %s; if it matches, run /verify %s\n", name, name)
+	return nil
+}
+
+func (r *repl) cmdContacts(string) error {
+	list := r.contacts.All()
+	if len(list) == 0 {
+		fmt.Fprintln(r.out, "No contacts pinned yet.")
+		return nil
+	}
+	for _, c := range list {
+		fmt.Fprintf(r.out, "  %-20s %-10s %s\n", c.Name, c.Trust, c.ID)
+	}
+	return nil
+}
+
+func (r *repl) cmdRename(args string) error {
+	ref, name, _ := strings.Cut(args, " ")
+	name = strings.TrimSpace(name)
+	if ref == "" || name == "" {
+		return errors.New("usage: /rename <peer> <name>")
+	}
+	c, err := r.contacts.Lookup(ref)
+	if err != nil {
+		return err
+	}
+	if err := r.contacts.Rename(c.ID, name); err != nil {
+		return err
+	}
+	fmt.Fprintf(r.out, "%s is now pinned as %s\n", shortID(c.ID), name)
+	return nil
+}
+
+// setTrust returns a command handler that moves a contact to the given trust level
+func (r *repl) setTrust(name string, trust contacts.Trust) func(string) error {
+	return func(args string) error {
+		if args == "" {
+			return fmt.Errorf("usage: %s <peer>", name)
+		}
+		c, err := r.contacts.Lookup(args)
+		if err != nil {
+			return err
+		}
+		if err := r.contacts.SetTrust(c.ID, trust); err != nil {
+			return err
+		}
+		switch trust {
+		case contacts.Verified, contacts.Trusted:
+			r.pm.Supervise(c.ID)
+		case contacts.Blocked:
+			r.pm.Unsupervise(c.ID)
+			r.pm.RemovePeer(c.ID)
+		default:
+			r.pm.Unsupervise(c.ID)
+		}
+		fmt.Fprintf(r.out, "%s is now %s\n", c.Name, trust)
+		return nil
+	}
+}
+
+func (r *repl) cmdSendFile(args string) error {
+	ref, path, _ := strings.Cut(args, " ")
+	path = strings.TrimSpace(path)
+	if ref == "" || path == "" {
+		return errors.New("usage: /send-file <peer> <path>")
+	}
+	peer, err := r.pm.Lookup(ref)
+	if err != nil {
+		return err
+	}
+	transfer, err := r.pm.SendFile(peer.ID, path)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(r.out, "[FILE] Offered %s (%s) to %s as %s\n", transfer.Name, formatSize(transfer.Size), displayName(peer), transfer.ID)
+	return nil
+}
+
+func (r *repl) cmdAccept(args string) error {
+	id, dir, _ := strings.Cut(args, " ")
+	dir = strings.TrimSpace(dir)
+	if id == "" {
+		return errors.New("usage: /accept <id> [dir]")
+	}
+	if dir == "" {
+		dir = r.downloadDir
+	}
+	offer, err := r.findOffer(id)
+	if err != nil {
+		return err
+	}
+	return r.pm.AcceptFile(offer.PeerID, offer.ID, dir)
+}
+
+func (r *repl) cmdReject(args string) error {
+	if args == "" {
+		return errors.New("usage: /reject <id>")
+	}
+	offer, err := r.findOffer(args)
+	if err != nil {
+		return err
+	}
+	return r.pm.RejectFile(offer.PeerID, offer.ID, "declined by user")
+}
+
+func (r *repl) cmdTransfers(string) error {
+	transfers := r.pm.Transfers()
+	if len(transfers) == 0 {
+		fmt.Fprintln(r.out, "No file transfers.")
+		return nil
+	}
+	for _, t := range transfers {
+		status := "offered"
+		switch {
+		case t.Err != nil:
+			status = t.Err.Error()
+		case t.Accepted && t.Size > 0:
+			status = fmt.Sprintf("%d%%", t.Transferred*100/t.Size)
+		case t.Accepted:
+			status = "accepted"
+		}
+		fmt.Fprintf(r.out, "  %s  %-9s %-20s %-24s %8s  %s\n",
+			t.ID, t.Direction, r.peerLabel(t.PeerID), t.Name, formatSize(t.Size), status)
+	}
+	return nil
+}
+
+// findOffer returns the incoming transfer whose ID starts with id
+func (r *repl) findOffer(id string) (comms.FileTransfer, error) {
+	var matches []comms.FileTransfer
+	for _, t := range r.pm.Transfers() {
+		if t.Direction == comms.FileReceiving && strings.HasPrefix(t.ID, id) {
+			matches = append(matches, t)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return comms.FileTransfer{}, fmt.Errorf("no file offer %s", id)
+	case 1:
+		return matches[0], nil
+	default:
+		return comms.FileTransfer{}, fmt.Errorf("ambiguous file offer %s", id)
+	}
+}
+
+func (r *repl) cmdOutbox(string) error {
+	if r.outbox == nil {
+		return errors.New("outbox disabled")
+	}
+	entries := r.outbox.Entries()
+	if len(entries) == 0 {
+		fmt.Fprintln(r.out, "Outbox is empty.")
+		return nil
+	}
+	for _, entry := range entries {
+		fmt.Fprintf(r.out, "  %s  %-20s %-8s queued %s, expires %s\n", entry.ID, r.peerLabel(entry.PeerID),
+			entry.Status, entry.Queued.Format("01-02 15:04"), entry.Expires.Format("01-02 15:04"))
+	}
+	return nil
+}
+
+func (r *repl) cmdQuit(string) error {
+	return errQuit
+}
+
+// otherPeers returns all known peers except ourselves, sorted by name
+func (r *repl) otherPeers() []comms.PeerInfo {
+	myID := r.self.GetID()
+	var peers []comms.PeerInfo
+	for _, peer := range r.pm.AllPeers() {
+		if peer.ID != myID {
+			peers = append(peers, peer)
+		}
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return displayName(peers[i]) < displayName(peers[j])
+	})
+	return peers
+}
+
+// track remembers a sent message so receipts can be matched to it
+func (r *repl) track(id, to, status string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent[id] = &sentMessage{to: to, status: status}
+}
+
+// setStatus moves a sent message forward to status and reports whether it changed
+func (r *repl) setStatus(id, status string) (sentMessage, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	msg, ok := r.sent[id]
+	if !ok || statusRank[status] <= statusRank[msg.status] {
+		return sentMessage{}, false
+	}
+	msg.status = status
+	return *msg, true
+}
+
+func (r *repl) status(id string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if msg, ok := r.sent[id]; ok {
+		return msg.status
+	}
+	return ""
+}
+
+// markRead sends a read receipt, reporting but not failing on errors
+func (r *repl) markRead(peerID, id string) {
+	if err := r.pm.MarkRead(peerID, id); err != nil && !errors.Is(err, comms.ErrFeatureUnsupported) {
+		fmt.Fprintf(r.out, "[ERROR] Failed to send read receipt: %v\n", err)
+	}
+}
+
+// resolve finds a peer ID and display name among known peers, then pinned contacts
+func (r *repl) resolve(ref string) (string, string, error) {
+	if peer, err := r.pm.Lookup(ref); err == nil {
+		return peer.ID, displayName(peer), nil
+	}
+	c, err := r.contacts.Lookup(ref)
+	if err != nil {
+		return "", "", err
+	}
+	return c.ID, c.Name, nil
+}
+
+func (r *repl) peerLabel(peerID string) string {
+	if peer, err := r.pm.Lookup(peerID); err == nil {
+		return displayName(peer)
+	}
+	return shortID(peerID)
+}
+
+func newMessage(topic, content string) *models.Envelope {
+	return &models.Envelope{
+		Type: "message",
+		Payload: &models.Envelope_Message{
+			Message: &models.TopicMessage{Topic: topic, Content: content},
+		},
+	}
+}
+
+func displayName(peer comms.PeerInfo) string {
+	if peer.Name != "" {
+		return peer.Name
+	}
+	return shortID(peer.ID)
+}
+
+func formatSize(size uint64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := uint64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
@@ -1,44 +1,321 @@
+// Package config resolves the node settings from, in increasing priority,
+// the defaults, a JSON config file, environment variables and command-line
+// flags.
 package config
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net"
 	"os"
-	"strconv"
+	"path/filepath"
+	"slices"
+	"strings"
 	"time"
+
+	"github.com/eglochon/simple-lan-messaging/pkg/comms"
+	"github.com/eglochon/simple-lan-messaging/pkg/discovery"
+	"github.com/eglochon/simple-lan-messaging/pkg/node"
 )
 
-// UDP Multicast Address to be used in discovery service
-var ANNOUNCE_ADDR string
+// Config holds every setting of the node
+type Config struct {
+	// Identity file, empty for identity.json in the working directory
+	IdentityPath string
+	// Protect the identity file with a passphrase, migrating plaintext files
+	IdentityEncrypt bool
+	// Announced display name, empty for the hostname
+	Name string
+
+	// The port where to listen
+	ServicePort uint16
+	// UDP multicast group used by the discovery service
+	AnnounceAddr string
+	// Duration interval for announcing in discovery service
+	AnnounceInterval time.Duration
+	// Network interface to announce and listen on, empty for every eligible one
+	Interface string
+	// Local IPv4 address to announce and listen on, empty for every eligible one
+	Bind string
+
+	// Reject peers whose name is pinned to another key, even for unverified contacts
+	ContactsStrict bool
+	// Largest message in bytes that will be sent or reassembled from chunks
+	MaxMessageSize int
+	// Directory where accepted files are stored
+	DownloadDir string
+	// How long messages for offline peers stay queued before they expire
+	OutboxTTL time.Duration
+	// Tell senders when their direct messages have been displayed
+	ReadReceipts bool
+	// Unix socket of the local control API, empty for the working directory
+	ControlSocket string
+
+	// Rekey a connection after this many messages, bytes or elapsed time (0 disables a trigger)
+	RekeyMessages uint64
+	RekeyBytes    uint64
+	RekeyInterval time.Duration
+
+	// Probe connections this often and drop them when no answer came within the timeout (0 disables)
+	KeepaliveInterval time.Duration
+	KeepaliveTimeout  time.Duration
 
-// Duration interval for announcing in discovery service
-var ANNOUNCE_INTERVAL time.Duration = time.Duration(3) * time.Second
+	// Redial verified and trusted contacts with a delay doubling from the min to the max delay
+	ReconnectMinDelay time.Duration
+	ReconnectMaxDelay time.Duration
+	// Give up on connecting to a peer after this long, including the handshake (0 waits forever)
+	DialTimeout time.Duration
 
-// The port where to listen
-var SERVICE_PORT uint16 = 40480
+	// Silent peers turn idle, then offline, and are forgotten after PeerTTL (0 keeps them)
+	PresenceIdle    time.Duration
+	PresenceOffline time.Duration
+	PeerTTL         time.Duration
+}
+
+// Default returns the settings used when nothing overrides them, the same
+// the node and peer manager use on their own
+func Default() Config {
+	return Config{
+		ServicePort:       node.DefaultPort,
+		AnnounceAddr:      discovery.DefaultGroup,
+		AnnounceInterval:  node.DefaultAnnounceInterval,
+		MaxMessageSize:    comms.DefaultMaxMessageSize,
+		DownloadDir:       "downloads",
+		OutboxTTL:         node.DefaultOutboxTTL,
+		ReadReceipts:      true,
+		RekeyMessages:     comms.DefaultRekeyPolicy.Messages,
+		RekeyBytes:        comms.DefaultRekeyPolicy.Bytes,
+		RekeyInterval:     comms.DefaultRekeyPolicy.Interval,
+		KeepaliveInterval: comms.DefaultKeepalivePolicy.Interval,
+		KeepaliveTimeout:  comms.DefaultKeepalivePolicy.Timeout,
+		ReconnectMinDelay: comms.DefaultReconnectPolicy.MinDelay,
+		ReconnectMaxDelay: comms.DefaultReconnectPolicy.MaxDelay,
+		DialTimeout:       comms.DefaultReconnectPolicy.DialTimeout,
+		PresenceIdle:      comms.DefaultPresencePolicy.IdleAfter,
+		PresenceOffline:   comms.DefaultPresencePolicy.OfflineAfter,
+		PeerTTL:           comms.DefaultPresencePolicy.EvictAfter,
+	}
+}
 
-func Setup() {
-	// Get ANNOUNCE_ADDR env variable
-	multicastAddr, exists := os.LookupEnv("ANNOUNCE_ADDR")
-	if exists && multicastAddr != "" {
-		ANNOUNCE_ADDR = multicastAddr
-	} else {
-		ANNOUNCE_ADDR = "224.0.0.250:40400"
+// DefaultPath returns the config file location, config.json in the
+// simple-lan-messaging directory of the user config dir ($XDG_CONFIG_HOME or
+// ~/.config on Linux), or an empty string if there is no such directory
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
 	}
+	return filepath.Join(dir, "simple-lan-messaging", "config.json")
+}
+
+// Error reports a setting that could not be applied
+type Error struct {
+	Source string // config file path, "environment" or "flag"
+	Key    string // the setting as spelled in its source
+	Value  string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s=%q: %v", e.Source, e.Key, e.Value, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Load layers the config file, the environment and the flags in args over
+// the defaults and validates the result. The file is read from --config,
+// CONFIG_FILE or DefaultPath, in that order; only the default file may be
+// missing. It returns flag.ErrHelp when args ask for the usage.
+func Load(args []string) (Config, error) {
+	cfg := Default()
 
-	// Get SERVICE_PORT env variable
-	servicePort, exists := os.LookupEnv("SERVICE_PORT")
-	if exists && servicePort != "" {
-		port, err := strconv.Atoi(servicePort)
-		if err == nil {
-			SERVICE_PORT = uint16(port)
+	// Flags win over everything, so they are parsed first and applied last
+	type flagValue struct {
+		s     *setting
+		value string
+	}
+	var flagValues []flagValue
+	var path string
+	fs := flag.NewFlagSet("simple-lan-messaging", flag.ContinueOnError)
+	fs.StringVar(&path, "config", "", "config `file` (default "+DefaultPath()+")")
+	for i := range settings {
+		s := &settings[i]
+		record := func(value string) error {
+			flagValues = append(flagValues, flagValue{s, value})
+			return nil
+		}
+		if s.boolean {
+			fs.BoolFunc(s.flagName(), s.usage, record)
+		} else {
+			fs.Func(s.flagName(), s.usage, record)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+	if fs.NArg() > 0 {
+		return cfg, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	var errs []error
+	if path == "" {
+		path, _ = os.LookupEnv("CONFIG_FILE")
+	}
+	if err := cfg.loadFile(path); err != nil {
+		errs = append(errs, err)
+	}
+	for i := range settings {
+		s := &settings[i]
+		value, exists := os.LookupEnv(s.env)
+		if exists && value != "" {
+			if err := s.parse(&cfg, value); err != nil {
+				errs = append(errs, &Error{Source: "environment", Key: s.env, Value: value, Err: err})
+			}
+		}
+	}
+	for _, f := range flagValues {
+		if err := f.s.parse(&cfg, f.value); err != nil {
+			errs = append(errs, &Error{Source: "flag", Key: "--" + f.s.flagName(), Value: f.value, Err: err})
 		}
 	}
+	if len(errs) > 0 {
+		return cfg, errors.Join(errs...)
+	}
+	return cfg, cfg.Validate()
+}
 
-	// Get ANNOUNCE_INTERVAL env variable
-	announceInterval, exists := os.LookupEnv("ANNOUNCE_INTERVAL")
-	if exists && announceInterval != "" {
-		seconds, err := strconv.Atoi(announceInterval)
-		if err == nil {
-			ANNOUNCE_INTERVAL = time.Duration(seconds) * time.Second
+// loadFile applies the settings of a JSON object such as
+// {"name": "alice", "service_port": 40481, "keepalive_interval": "20s"}.
+// An empty path selects DefaultPath, which may be missing.
+func (c *Config) loadFile(path string) error {
+	optional := path == ""
+	if optional {
+		path = DefaultPath()
+		if path == "" {
+			return nil
 		}
 	}
+	data, err := os.ReadFile(path)
+	if optional && errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var values map[string]json.RawMessage
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&values); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return fmt.Errorf("%s: expected an object of settings, found %s", path, typeErr.Value)
+		}
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return fmt.Errorf("%s: unexpected data after the settings object", path)
+	}
+
+	var errs []error
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		raw := values[key]
+		value := string(raw)
+		s := lookupSetting(key)
+		if s == nil {
+			errs = append(errs, &Error{Source: path, Key: key, Value: value, Err: errors.New("unknown setting")})
+			continue
+		}
+		// Strings are unquoted, numbers and booleans are used as written
+		if strings.HasPrefix(value, `"`) {
+			if err := json.Unmarshal(raw, &value); err != nil {
+				errs = append(errs, &Error{Source: path, Key: key, Value: string(raw), Err: err})
+				continue
+			}
+		} else if value == "null" || strings.HasPrefix(value, "{") || strings.HasPrefix(value, "[") {
+			errs = append(errs, &Error{Source: path, Key: key, Value: value, Err: errors.New("expected a string, number or boolean")})
+			continue
+		}
+		if err := s.parse(c, value); err != nil {
+			errs = append(errs, &Error{Source: path, Key: key, Value: value, Err: err})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Validate checks the settings against each other and against what the
+// network accepts, reporting every problem found
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.ServicePort == 0 {
+		fail("service_port must be set")
+	}
+	if err := validateGroup(c.AnnounceAddr); err != nil {
+		fail("announce_addr %q: %v", c.AnnounceAddr, err)
+	}
+	if c.AnnounceInterval <= 0 {
+		fail("announce_interval must be positive")
+	}
+	if c.Interface != "" {
+		if _, err := net.InterfaceByName(c.Interface); err != nil {
+			fail("interface %q: %v", c.Interface, err)
+		}
+	}
+	if c.Bind != "" && net.ParseIP(c.Bind).To4() == nil {
+		fail("bind %q is not an IPv4 address", c.Bind)
+	}
+	if c.MaxMessageSize <= 0 {
+		fail("max_message_size must be positive")
+	}
+	if c.DownloadDir == "" {
+		fail("download_dir must be set")
+	}
+	if c.OutboxTTL <= 0 {
+		fail("outbox_ttl must be positive")
+	}
+	if c.KeepaliveInterval > 0 && c.KeepaliveTimeout <= 0 {
+		fail("keepalive_timeout must be positive while keepalives are enabled")
+	}
+	if c.ReconnectMinDelay <= 0 {
+		fail("reconnect_min_delay must be positive")
+	}
+	if c.ReconnectMaxDelay < c.ReconnectMinDelay {
+		fail("reconnect_max_delay (%s) is shorter than reconnect_min_delay (%s)", c.ReconnectMaxDelay, c.ReconnectMinDelay)
+	}
+	if c.PresenceIdle <= 0 {
+		fail("presence_idle must be positive")
+	}
+	if c.PresenceOffline <= c.PresenceIdle {
+		fail("presence_offline (%s) must be longer than presence_idle (%s)", c.PresenceOffline, c.PresenceIdle)
+	}
+	if c.PeerTTL != 0 && c.PeerTTL < c.PresenceOffline {
+		fail("peer_ttl (%s) is shorter than presence_offline (%s)", c.PeerTTL, c.PresenceOffline)
+	}
+	return errors.Join(errs...)
+}
+
+// validateGroup checks that addr is an IPv4 multicast group with a port
+func validateGroup(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host).To4()
+	if ip == nil || !ip.IsMulticast() {
+		return errors.New("not an IPv4 multicast address")
+	}
+	if _, err := parsePort(port); err != nil {
+		return err
+	}
+	return nil
 }
@@ -0,0 +1,227 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// isolate keeps Load away from the user's config file and environment
+func isolate(t *testing.T) {
+	t.Helper()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("CONFIG_FILE", "")
+	for _, s := range settings {
+		t.Setenv(s.env, "")
+	}
+}
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseDuration(t *testing.T) {
+	for _, tc := range []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"30s", 30 * time.Second, false},
+		{"1m30s", 90 * time.Second, false},
+		{"45", 45 * time.Second, false},
+		{"0", 0, false},
+		{"0s", 0, false},
+		{"-5s", 0, true},
+		{"-5", 0, true},
+		{"soon", 0, true},
+		{"", 0, true},
+	} {
+		got, err := parseDuration(tc.value)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("parseDuration(%q) = %s, %v; want %s, error %t", tc.value, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestParsePort(t *testing.T) {
+	for _, tc := range []struct {
+		value   string
+		want    uint16
+		wantErr bool
+	}{
+		{"40480", 40480, false},
+		{"1", 1, false},
+		{"65535", 65535, false},
+		{"0", 0, true},
+		{"65536", 0, true},
+		{"-1", 0, true},
+		{"http", 0, true},
+	} {
+		got, err := parsePort(tc.value)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("parsePort(%q) = %d, %v; want %d, error %t", tc.value, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		file  string
+		env   map[string]string
+		args  []string
+		check func(t *testing.T, c Config)
+	}{
+		{
+			name: "defaults",
+			check: func(t *testing.T, c Config) {
+				if c != Default() {
+					t.Errorf("got %+v, want the defaults", c)
+				}
+			},
+		},
+		{
+			name: "file",
+			file: `{"name": "alice", "service_port": 40481, "keepalive_interval": "20s", "read_receipts": false, "rekey_messages": 500}`,
+			check: func(t *testing.T, c Config) {
+				if c.Name != "alice" || c.ServicePort != 40481 || c.KeepaliveInterval != 20*time.Second || c.ReadReceipts || c.RekeyMessages != 500 {
+					t.Errorf("file settings not applied: %+v", c)
+				}
+			},
+		},
+		{
+			name: "environment over file",
+			file: `{"name": "alice", "service_port": 40481}`,
+			env:  map[string]string{"SERVICE_PORT": "40482"},
+			check: func(t *testing.T, c Config) {
+				if c.Name != "alice" || c.ServicePort != 40482 {
+					t.Errorf("got name %q, port %d; want alice, 40482", c.Name, c.ServicePort)
+				}
+			},
+		},
+		{
+			name: "flags over environment",
+			env:  map[string]string{"SERVICE_PORT": "40482", "DISPLAY_NAME": "bob"},
+			args: []string{"--service-port", "40483", "--read-receipts=false"},
+			check: func(t *testing.T, c Config) {
+				if c.Name != "bob" || c.ServicePort != 40483 || c.ReadReceipts {
+					t.Errorf("got name %q, port %d, receipts %t; want bob, 40483, false", c.Name, c.ServicePort, c.ReadReceipts)
+				}
+			},
+		},
+		{
+			name: "zero disables",
+			args: []string{"--keepalive-interval", "0", "--rekey-messages", "0", "--rekey-bytes", "0", "--rekey-interval", "0", "--peer-ttl", "0", "--dial-timeout", "0"},
+			check: func(t *testing.T, c Config) {
+				if c.KeepaliveInterval != 0 || c.RekeyMessages != 0 || c.RekeyBytes != 0 || c.RekeyInterval != 0 || c.PeerTTL != 0 || c.DialTimeout != 0 {
+					t.Errorf("zero values not kept: %+v", c)
+				}
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			isolate(t)
+			if tc.file != "" {
+				t.Setenv("CONFIG_FILE", writeConfig(t, tc.file))
+			}
+			for key, value := range tc.env {
+				t.Setenv(key, value)
+			}
+			c, err := Load(tc.args)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			tc.check(t, c)
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		file    string
+		env     map[string]string
+		args    []string
+		source  string // of the *Error expected, empty for other errors
+		key     string
+		message string
+	}{
+		{name: "unknown setting", file: `{"colour": "blue"}`, source: "file", key: "colour", message: "unknown setting"},
+		{name: "file type", file: `{"service_port": "many"}`, source: "file", key: "service_port", message: "not a port"},
+		{name: "file object value", file: `{"name": {"first": "alice"}}`, source: "file", key: "name", message: "expected a string, number or boolean"},
+		{name: "file not an object", file: `["name"]`, message: "expected an object of settings, found array"},
+		{name: "file trailing data", file: `{} {}`, message: "unexpected data after the settings object"},
+		{name: "environment", env: map[string]string{"KEEPALIVE_INTERVAL": "often"}, source: "environment", key: "KEEPALIVE_INTERVAL", message: "not a duration"},
+		{name: "negative duration", args: []string{"--outbox-ttl", "-1h"}, source: "flag", key: "--outbox-ttl", message: "must not be negative"},
+		{name: "boolean", args: []string{"--contacts-strict=maybe"}, source: "flag", key: "--contacts-strict", message: "not a boolean"},
+		{name: "count", args: []string{"--rekey-bytes", "-1"}, source: "flag", key: "--rekey-bytes", message: "not a non-negative integer"},
+		{name: "message size", args: []string{"--max-message-size", "0"}, source: "flag", key: "--max-message-size", message: "must be positive"},
+		{name: "argument", args: []string{"extra"}, message: `unexpected argument "extra"`},
+		{name: "missing file", args: []string{"--config", "/nonexistent/config.json"}, message: "no such file"},
+		{name: "group", args: []string{"--announce-addr", "10.0.0.1:40400"}, message: "not an IPv4 multicast address"},
+		{name: "keepalive timeout", args: []string{"--keepalive-timeout", "0"}, message: "keepalive_timeout must be positive"},
+		{name: "reconnect delays", args: []string{"--reconnect-min-delay", "1m", "--reconnect-max-delay", "10s"}, message: "reconnect_max_delay (10s) is shorter"},
+		{name: "presence order", args: []string{"--presence-idle", "1m", "--presence-offline", "30s"}, message: "presence_offline (30s) must be longer"},
+		{name: "peer ttl", args: []string{"--peer-ttl", "10s"}, message: "peer_ttl (10s) is shorter"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			isolate(t)
+			var path string
+			if tc.file != "" {
+				path = writeConfig(t, tc.file)
+				t.Setenv("CONFIG_FILE", path)
+			}
+			for key, value := range tc.env {
+				t.Setenv(key, value)
+			}
+
+			_, err := Load(tc.args)
+			if err == nil || !strings.Contains(err.Error(), tc.message) {
+				t.Fatalf("got error %v, want one containing %q", err, tc.message)
+			}
+			if tc.source == "" {
+				return
+			}
+			var cfgErr *Error
+			if !errors.As(err, &cfgErr) {
+				t.Fatalf("got %T, want *Error", err)
+			}
+			source := tc.source
+			if source == "file" {
+				source = path
+			}
+			if cfgErr.Source != source || cfgErr.Key != tc.key {
+				t.Errorf("error from %s key %s, want %s key %s", cfgErr.Source, cfgErr.Key, source, tc.key)
+			}
+		})
+	}
+}
+
+func TestLoadReportsEveryError(t *testing.T) {
+	isolate(t)
+	t.Setenv("CONFIG_FILE", writeConfig(t, `{"service_port": 0, "colour": "blue"}`))
+	t.Setenv("DIAL_TIMEOUT", "later")
+
+	_, err := Load([]string{"--max-message-size", "big"})
+	for _, want := range []string{"service_port", "colour", "DIAL_TIMEOUT", "--max-message-size"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("error %v doesn't mention %s", err, want)
+		}
+	}
+}
+
+func TestLoadHelp(t *testing.T) {
+	isolate(t)
+	if _, err := Load([]string{"--help"}); !errors.Is(err, flag.ErrHelp) {
+		t.Fatalf("got %v, want flag.ErrHelp", err)
+	}
+}
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setting is one configurable value. It is called name in the config file,
+// env in the environment and name with dashes on the command line.
+type setting struct {
+	name    string
+	env     string
+	usage   string
+	boolean bool
+	parse   func(c *Config, value string) error
+}
+
+func (s *setting) flagName() string {
+	return strings.ReplaceAll(s.name, "_", "-")
+}
+
+var settings = []setting{
+	stringSetting("identity_path", "IDENTITY_PATH", "identity `file`", func(c *Config) *string { return &c.IdentityPath }),
+	boolSetting("identity_encrypt", "IDENTITY_ENCRYPT", "protect the identity file with a passphrase", func(c *Config) *bool { return &c.IdentityEncrypt }),
+	stringSetting("name", "DISPLAY_NAME", "announced display `name` (default the hostname)", func(c *Config) *string { return &c.Name }),
+
+	{name: "service_port", env: "SERVICE_PORT", usage: "TCP `port` to listen on", parse: func(c *Config, value string) (err error) {
+		c.ServicePort, err = parsePort(value)
+		return err
+	}},
+	stringSetting("announce_addr", "ANNOUNCE_ADDR", "multicast `group:port` for discovery", func(c *Config) *string { return &c.AnnounceAddr }),
+	durationSetting("announce_interval", "ANNOUNCE_INTERVAL", "time between discovery announcements", func(c *Config) *time.Duration { return &c.AnnounceInterval }),
+	stringSetting("interface", "INTERFACE", "only use this network `interface` (default every eligible one)", func(c *Config) *string { return &c.Interface }),
+	stringSetting("bind", "BIND_ADDR", "only use this local IPv4 `address`", func(c *Config) *string { return &c.Bind }),
+
+	boolSetting("contacts_strict", "CONTACTS_STRICT", "reject peers whose name is pinned to another key", func(c *Config) *bool { return &c.ContactsStrict }),
+	{name: "max_message_size", env: "MAX_MESSAGE_SIZE", usage: "largest message in `bytes`", parse: func(c *Config, value string) error {
+		size, err := strconv.Atoi(value)
+		if err != nil {
+			return errors.New("not an integer")
+		}
+		if size <= 0 {
+			return errors.New("must be positive")
+		}
+		c.MaxMessageSize = size
+		return nil
+	}},
+	stringSetting("download_dir", "DOWNLOAD_DIR", "`directory` for accepted files", func(c *Config) *string { return &c.DownloadDir }),
+	durationSetting("outbox_ttl", "OUTBOX_TTL", "how long messages for offline peers stay queued", func(c *Config) *time.Duration { return &c.OutboxTTL }),
+	boolSetting("read_receipts", "READ_RECEIPTS", "tell senders when their messages were displayed", func(c *Config) *bool { return &c.ReadReceipts }),
+	stringSetting("control_socket", "CONTROL_SOCKET", "unix socket `path` of the control API", func(c *Config) *string { return &c.ControlSocket }),
+
+	countSetting("rekey_messages", "REKEY_MESSAGES", "rekey a connection after this many messages (0 disables)", func(c *Config) *uint64 { return &c.RekeyMessages }),
+	countSetting("rekey_bytes", "REKEY_BYTES", "rekey a connection after this many bytes (0 disables)", func(c *Config) *uint64 { return &c.RekeyBytes }),
+	durationSetting("rekey_interval", "REKEY_INTERVAL", "rekey a connection after this long (0 disables)", func(c *Config) *time.Duration { return &c.RekeyInterval }),
+
+	durationSetting("keepalive_interval", "KEEPALIVE_INTERVAL", "time between keepalive pings (0 disables)", func(c *Config) *time.Duration { return &c.KeepaliveInterval }),
+	durationSetting("keepalive_timeout", "KEEPALIVE_TIMEOUT", "extra time allowed for a keepalive answer", func(c *Config) *time.Duration { return &c.KeepaliveTimeout }),
+
+	durationSetting("reconnect_min_delay", "RECONNECT_MIN_DELAY", "first delay before redialing a contact", func(c *Config) *time.Duration { return &c.ReconnectMinDelay }),
+	durationSetting("reconnect_max_delay", "RECONNECT_MAX_DELAY", "longest delay before redialing a contact", func(c *Config) *time.Duration { return &c.ReconnectMaxDelay }),
+	durationSetting("dial_timeout", "DIAL_TIMEOUT", "limit for connecting to a peer (0 waits forever)", func(c *Config) *time.Duration { return &c.DialTimeout }),
+
+	durationSetting("presence_idle", "PRESENCE_IDLE", "silence after which a peer is idle", func(c *Config) *time.Duration { return &c.PresenceIdle }),
+	durationSetting("presence_offline", "PRESENCE_OFFLINE", "silence after which a peer is offline", func(c *Config) *time.Duration { return &c.PresenceOffline }),
+	durationSetting("peer_ttl", "PEER_TTL", "silence after which a peer is forgotten (0 keeps it)", func(c *Config) *time.Duration { return &c.PeerTTL }),
+}
+
+// lookupSetting finds a setting by its config file name
+func lookupSetting(name string) *setting {
+	for i := range settings {
+		if settings[i].name == name {
+			return &settings[i]
+		}
+	}
+	return nil
+}
+
+func stringSetting(name, env, usage string, field func(*Config) *string) setting {
+	return setting{name: name, env: env, usage: usage, parse: func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}}
+}
+
+func boolSetting(name, env, usage string, field func(*Config) *bool) setting {
+	return setting{name: name, env: env, usage: usage, boolean: true, parse: func(c *Config, value string) error {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("not a boolean")
+		}
+		*field(c) = enabled
+		return nil
+	}}
+}
+
+func countSetting(name, env, usage string, field func(*Config) *uint64) setting {
+	return setting{name: name, env: env, usage: usage, parse: func(c *Config, value string) error {
+		count, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return errors.New("not a non-negative integer")
+		}
+		*field(c) = count
+		return nil
+	}}
+}
+
+func durationSetting(name, env, usage string, field func(*Config) *time.Duration) setting {
+	return setting{name: name, env: env, usage: usage, parse: func(c *Config, value string) error {
+		d, err := parseDuration(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = d
+		return nil
+	}}
+}
+
+// parseDuration accepts Go durations such as "1m30s", and plain integers as
+// seconds. Negative durations are rejected.
+func parseDuration(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, atoiErr := strconv.Atoi(value)
+		if atoiErr != nil {
+			return 0, errors.New(`not a duration such as "30s" or "5m"`)
+		}
+		d = time.Duration(seconds) * time.Second
+	}
+	if d < 0 {
+		return 0, errors.New("must not be negative")
+	}
+	return d, nil
+}
+
+func parsePort(value string) (uint16, error) {
+	port, err := strconv.ParseUint(value, 10, 16)
+	if err != nil || port == 0 {
+		return 0, fmt.Errorf("not a port between 1 and 65535")
+	}
+	return uint16(port), nil
+}
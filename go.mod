@@ -7,6 +7,7 @@ toolchain go1.23.10
 require (
 	golang.org/x/crypto v0.39.0
 	golang.org/x/net v0.41.0
+	golang.org/x/term v0.32.0
 	google.golang.org/protobuf v1.36.6
 )
 
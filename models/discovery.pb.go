@@ -25,11 +25,15 @@ type Discovery struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Id   string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`      // base64-encoded Ed25519 public key
-	Enc  string `protobuf:"bytes,2,opt,name=enc,proto3" json:"enc,omitempty"`    // base64-encoded X25519 public key
-	Name string `protobuf:"bytes,3,opt,name=name,proto3" json:"name,omitempty"`  // Friendly username
-	Ip   string `protobuf:"bytes,4,opt,name=ip,proto3" json:"ip,omitempty"`      // Optional IP address
-	Port uint32 `protobuf:"varint,5,opt,name=port,proto3" json:"port,omitempty"` // Service port
+	Id        string   `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`                // base64-encoded Ed25519 public key
+	Enc       string   `protobuf:"bytes,2,opt,name=enc,proto3" json:"enc,omitempty"`              // base64-encoded X25519 public key
+	Name      string   `protobuf:"bytes,3,opt,name=name,proto3" json:"name,omitempty"`            // Friendly username
+	Ip        string   `protobuf:"bytes,4,opt,name=ip,proto3" json:"ip,omitempty"`                // Optional IP address
+	Port      uint32   `protobuf:"varint,5,opt,name=port,proto3" json:"port,omitempty"`           // Service port
+	Timestamp int64    `protobuf:"varint,6,opt,name=timestamp,proto3" json:"timestamp,omitempty"` // Unix time in milliseconds when the announcement was signed
+	Sig       string   `protobuf:"bytes,7,opt,name=sig,proto3" json:"sig,omitempty"`              // base64 Ed25519 signature over all other fields
+	Topics    []string `protobuf:"bytes,8,rep,name=topics,proto3" json:"topics,omitempty"`        // topic patterns the sender subscribed to
+	Addrs     []string `protobuf:"bytes,9,rep,name=addrs,proto3" json:"addrs,omitempty"`          // every IP address the sender announces and listens on
 }
 
 func (x *Discovery) Reset() {
@@ -99,22 +103,56 @@ func (x *Discovery) GetPort() uint32 {
 	return 0
 }
 
+func (x *Discovery) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *Discovery) GetSig() string {
+	if x != nil {
+		return x.Sig
+	}
+	return ""
+}
+
+func (x *Discovery) GetTopics() []string {
+	if x != nil {
+		return x.Topics
+	}
+	return nil
+}
+
+func (x *Discovery) GetAddrs() []string {
+	if x != nil {
+		return x.Addrs
+	}
+	return nil
+}
+
 var File_models_discovery_proto protoreflect.FileDescriptor
 
 var file_models_discovery_proto_rawDesc = []byte{
 	0x0a, 0x16, 0x6d, 0x6f, 0x64, 0x65, 0x6c, 0x73, 0x2f, 0x64, 0x69, 0x73, 0x63, 0x6f, 0x76, 0x65,
 	0x72, 0x79, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x06, 0x6d, 0x6f, 0x64, 0x65, 0x6c, 0x73,
-	0x22, 0x65, 0x0a, 0x09, 0x44, 0x69, 0x73, 0x63, 0x6f, 0x76, 0x65, 0x72, 0x79, 0x12, 0x0e, 0x0a,
-	0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x64, 0x12, 0x10, 0x0a,
-	0x03, 0x65, 0x6e, 0x63, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x65, 0x6e, 0x63, 0x12,
-	0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e,
-	0x61, 0x6d, 0x65, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x70, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52,
-	0x02, 0x69, 0x70, 0x12, 0x12, 0x0a, 0x04, 0x70, 0x6f, 0x72, 0x74, 0x18, 0x05, 0x20, 0x01, 0x28,
-	0x0d, 0x52, 0x04, 0x70, 0x6f, 0x72, 0x74, 0x42, 0x38, 0x5a, 0x36, 0x67, 0x69, 0x74, 0x68, 0x75,
-	0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x65, 0x67, 0x6c, 0x6f, 0x63, 0x68, 0x6f, 0x6e, 0x2f, 0x73,
-	0x69, 0x6d, 0x70, 0x6c, 0x65, 0x2d, 0x6c, 0x61, 0x6e, 0x2d, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67,
-	0x69, 0x6e, 0x67, 0x2f, 0x6d, 0x6f, 0x64, 0x65, 0x6c, 0x73, 0x3b, 0x6d, 0x6f, 0x64, 0x65, 0x6c,
-	0x73, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x22, 0xc3, 0x01, 0x0a, 0x09, 0x44, 0x69, 0x73, 0x63, 0x6f, 0x76, 0x65, 0x72, 0x79, 0x12, 0x0e,
+	0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x64, 0x12, 0x10,
+	0x0a, 0x03, 0x65, 0x6e, 0x63, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x65, 0x6e, 0x63,
+	0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04,
+	0x6e, 0x61, 0x6d, 0x65, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x70, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x02, 0x69, 0x70, 0x12, 0x12, 0x0a, 0x04, 0x70, 0x6f, 0x72, 0x74, 0x18, 0x05, 0x20, 0x01,
+	0x28, 0x0d, 0x52, 0x04, 0x70, 0x6f, 0x72, 0x74, 0x12, 0x1c, 0x0a, 0x09, 0x74, 0x69, 0x6d, 0x65,
+	0x73, 0x74, 0x61, 0x6d, 0x70, 0x18, 0x06, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x74, 0x69, 0x6d,
+	0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x12, 0x10, 0x0a, 0x03, 0x73, 0x69, 0x67, 0x18, 0x07, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x03, 0x73, 0x69, 0x67, 0x12, 0x16, 0x0a, 0x06, 0x74, 0x6f, 0x70, 0x69,
+	0x63, 0x73, 0x18, 0x08, 0x20, 0x03, 0x28, 0x09, 0x52, 0x06, 0x74, 0x6f, 0x70, 0x69, 0x63, 0x73,
+	0x12, 0x14, 0x0a, 0x05, 0x61, 0x64, 0x64, 0x72, 0x73, 0x18, 0x09, 0x20, 0x03, 0x28, 0x09, 0x52,
+	0x05, 0x61, 0x64, 0x64, 0x72, 0x73, 0x42, 0x38, 0x5a, 0x36, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62,
+	0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x65, 0x67, 0x6c, 0x6f, 0x63, 0x68, 0x6f, 0x6e, 0x2f, 0x73, 0x69,
+	0x6d, 0x70, 0x6c, 0x65, 0x2d, 0x6c, 0x61, 0x6e, 0x2d, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x69,
+	0x6e, 0x67, 0x2f, 0x6d, 0x6f, 0x64, 0x65, 0x6c, 0x73, 0x3b, 0x6d, 0x6f, 0x64, 0x65, 0x6c, 0x73,
+	0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -25,10 +25,13 @@ type Handshake struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Id    string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`       // base64 Ed25519 public key
-	Enc   string `protobuf:"bytes,2,opt,name=enc,proto3" json:"enc,omitempty"`     // base64 ephemeral X25519 public key
-	Sig   string `protobuf:"bytes,3,opt,name=sig,proto3" json:"sig,omitempty"`     // signature of (id + enc + nonce)
-	Nonce string `protobuf:"bytes,4,opt,name=nonce,proto3" json:"nonce,omitempty"` // random nonce (to prevent replay)
+	Id         string   `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`                                    // base64 Ed25519 public key
+	Enc        string   `protobuf:"bytes,2,opt,name=enc,proto3" json:"enc,omitempty"`                                  // base64 ephemeral X25519 public key
+	Sig        string   `protobuf:"bytes,3,opt,name=sig,proto3" json:"sig,omitempty"`                                  // unused since version 2, see HandshakeAuth
+	Nonce      string   `protobuf:"bytes,4,opt,name=nonce,proto3" json:"nonce,omitempty"`                              // random nonce (to prevent replay)
+	Version    uint32   `protobuf:"varint,5,opt,name=version,proto3" json:"version,omitempty"`                         // highest protocol version spoken
+	MinVersion uint32   `protobuf:"varint,6,opt,name=min_version,json=minVersion,proto3" json:"min_version,omitempty"` // lowest protocol version accepted
+	Features   []string `protobuf:"bytes,7,rep,name=features,proto3" json:"features,omitempty"`                        // optional capabilities, see comms.Feature*
 }
 
 func (x *Handshake) Reset() {
@@ -91,21 +94,99 @@ func (x *Handshake) GetNonce() string {
 	return ""
 }
 
+func (x *Handshake) GetVersion() uint32 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *Handshake) GetMinVersion() uint32 {
+	if x != nil {
+		return x.MinVersion
+	}
+	return 0
+}
+
+func (x *Handshake) GetFeatures() []string {
+	if x != nil {
+		return x.Features
+	}
+	return nil
+}
+
+// HandshakeAuth follows the exchange of both Handshake messages and proves
+// possession of the identity key over the whole transcript
+type HandshakeAuth struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Sig []byte `protobuf:"bytes,1,opt,name=sig,proto3" json:"sig,omitempty"` // Ed25519 signature of role label + transcript hash
+}
+
+func (x *HandshakeAuth) Reset() {
+	*x = HandshakeAuth{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_models_handshake_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *HandshakeAuth) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HandshakeAuth) ProtoMessage() {}
+
+func (x *HandshakeAuth) ProtoReflect() protoreflect.Message {
+	mi := &file_models_handshake_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HandshakeAuth.ProtoReflect.Descriptor instead.
+func (*HandshakeAuth) Descriptor() ([]byte, []int) {
+	return file_models_handshake_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *HandshakeAuth) GetSig() []byte {
+	if x != nil {
+		return x.Sig
+	}
+	return nil
+}
+
 var File_models_handshake_proto protoreflect.FileDescriptor
 
 var file_models_handshake_proto_rawDesc = []byte{
 	0x0a, 0x16, 0x6d, 0x6f, 0x64, 0x65, 0x6c, 0x73, 0x2f, 0x68, 0x61, 0x6e, 0x64, 0x73, 0x68, 0x61,
 	0x6b, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x06, 0x6d, 0x6f, 0x64, 0x65, 0x6c, 0x73,
-	0x22, 0x55, 0x0a, 0x09, 0x48, 0x61, 0x6e, 0x64, 0x73, 0x68, 0x61, 0x6b, 0x65, 0x12, 0x0e, 0x0a,
-	0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x64, 0x12, 0x10, 0x0a,
-	0x03, 0x65, 0x6e, 0x63, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x65, 0x6e, 0x63, 0x12,
-	0x10, 0x0a, 0x03, 0x73, 0x69, 0x67, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x73, 0x69,
-	0x67, 0x12, 0x14, 0x0a, 0x05, 0x6e, 0x6f, 0x6e, 0x63, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09,
-	0x52, 0x05, 0x6e, 0x6f, 0x6e, 0x63, 0x65, 0x42, 0x38, 0x5a, 0x36, 0x67, 0x69, 0x74, 0x68, 0x75,
-	0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x65, 0x67, 0x6c, 0x6f, 0x63, 0x68, 0x6f, 0x6e, 0x2f, 0x73,
-	0x69, 0x6d, 0x70, 0x6c, 0x65, 0x2d, 0x6c, 0x61, 0x6e, 0x2d, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67,
-	0x69, 0x6e, 0x67, 0x2f, 0x6d, 0x6f, 0x64, 0x65, 0x6c, 0x73, 0x3b, 0x6d, 0x6f, 0x64, 0x65, 0x6c,
-	0x73, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x22, 0xac, 0x01, 0x0a, 0x09, 0x48, 0x61, 0x6e, 0x64, 0x73, 0x68, 0x61, 0x6b, 0x65, 0x12, 0x0e,
+	0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x64, 0x12, 0x10,
+	0x0a, 0x03, 0x65, 0x6e, 0x63, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x65, 0x6e, 0x63,
+	0x12, 0x10, 0x0a, 0x03, 0x73, 0x69, 0x67, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x73,
+	0x69, 0x67, 0x12, 0x14, 0x0a, 0x05, 0x6e, 0x6f, 0x6e, 0x63, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x05, 0x6e, 0x6f, 0x6e, 0x63, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x76, 0x65, 0x72, 0x73,
+	0x69, 0x6f, 0x6e, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69,
+	0x6f, 0x6e, 0x12, 0x1f, 0x0a, 0x0b, 0x6d, 0x69, 0x6e, 0x5f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f,
+	0x6e, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0a, 0x6d, 0x69, 0x6e, 0x56, 0x65, 0x72, 0x73,
+	0x69, 0x6f, 0x6e, 0x12, 0x1a, 0x0a, 0x08, 0x66, 0x65, 0x61, 0x74, 0x75, 0x72, 0x65, 0x73, 0x18,
+	0x07, 0x20, 0x03, 0x28, 0x09, 0x52, 0x08, 0x66, 0x65, 0x61, 0x74, 0x75, 0x72, 0x65, 0x73, 0x22,
+	0x21, 0x0a, 0x0d, 0x48, 0x61, 0x6e, 0x64, 0x73, 0x68, 0x61, 0x6b, 0x65, 0x41, 0x75, 0x74, 0x68,
+	0x12, 0x10, 0x0a, 0x03, 0x73, 0x69, 0x67, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x03, 0x73,
+	0x69, 0x67, 0x42, 0x38, 0x5a, 0x36, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d,
+	0x2f, 0x65, 0x67, 0x6c, 0x6f, 0x63, 0x68, 0x6f, 0x6e, 0x2f, 0x73, 0x69, 0x6d, 0x70, 0x6c, 0x65,
+	0x2d, 0x6c, 0x61, 0x6e, 0x2d, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x69, 0x6e, 0x67, 0x2f, 0x6d,
+	0x6f, 0x64, 0x65, 0x6c, 0x73, 0x3b, 0x6d, 0x6f, 0x64, 0x65, 0x6c, 0x73, 0x62, 0x06, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -120,9 +201,10 @@ func file_models_handshake_proto_rawDescGZIP() []byte {
 	return file_models_handshake_proto_rawDescData
 }
 
-var file_models_handshake_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_models_handshake_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
 var file_models_handshake_proto_goTypes = []interface{}{
-	(*Handshake)(nil), // 0: models.Handshake
+	(*Handshake)(nil),     // 0: models.Handshake
+	(*HandshakeAuth)(nil), // 1: models.HandshakeAuth
 }
 var file_models_handshake_proto_depIdxs = []int32{
 	0, // [0:0] is the sub-list for method output_type
@@ -150,6 +232,18 @@ func file_models_handshake_proto_init() {
 				return nil
 			}
 		}
+		file_models_handshake_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*HandshakeAuth); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -157,7 +251,7 @@ func file_models_handshake_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_models_handshake_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   1,
+			NumMessages:   2,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
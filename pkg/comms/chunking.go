@@ -0,0 +1,124 @@
+package comms
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/eglochon/simple-lan-messaging/models"
+)
+
+// DefaultMaxMessageSize bounds a reassembled message unless changed with SetMaxMessageSize
+const DefaultMaxMessageSize = 16 << 20
+
+// chunkSize is the payload carried by each chunk, leaving room for the envelope around it
+const chunkSize = 32 << 10
+
+var ErrMessageTooLarge = errors.New("message too large")
+
+// SetMaxMessageSize changes the largest message that will be sent or reassembled.
+func (pm *PeerManager) SetMaxMessageSize(size int) {
+	pm.maxMessageSize.Store(int64(size))
+}
+
+// MaxMessageSize returns the largest message that will be sent or reassembled.
+func (pm *PeerManager) MaxMessageSize() int {
+	return int(pm.maxMessageSize.Load())
+}
+
+// writeMessage sends data as a single frame, or as a series of chunk
+// envelopes when it doesn't fit in one
+func (pm *PeerManager) writeMessage(conn *SecureConn, data []byte) error {
+	if len(data) <= MaxFrameSize {
+		return conn.WriteEncrypted(data)
+	}
+	if int64(len(data)) > pm.maxMessageSize.Load() {
+		return fmt.Errorf("%w: %d bytes", ErrMessageTooLarge, len(data))
+	}
+	if !conn.HasFeature(FeatureChunking) {
+		return fmt.Errorf("%w: %d bytes don't fit in one frame and the peer can't reassemble chunks", ErrMessageTooLarge, len(data))
+	}
+
+	id := pm.chunkSeq.Add(1)
+	total := (len(data) + chunkSize - 1) / chunkSize
+	for i := 0; i < total; i++ {
+		end := min((i+1)*chunkSize, len(data))
+		frame, err := marshalProto(&models.Envelope{
+			Type: "chunk",
+			Payload: &models.Envelope_Chunk{
+				Chunk: &models.Chunk{
+					Id:    id,
+					Index: uint32(i),
+					Total: uint32(total),
+					Data:  data[i*chunkSize : end],
+				},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		if err := conn.WriteEncrypted(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// assembler collects the chunks received on one connection
+type assembler struct {
+	maxSize int64
+	pending int64 // bytes buffered across all partial messages
+	partial map[uint64]*partialMessage
+}
+
+type partialMessage struct {
+	total uint32
+	next  uint32
+	data  []byte
+}
+
+func newAssembler(maxSize int64) *assembler {
+	return &assembler{
+		maxSize: maxSize,
+		partial: make(map[uint64]*partialMessage),
+	}
+}
+
+// add stores a chunk and returns the full message once its last chunk arrived
+func (a *assembler) add(chunk *models.Chunk) ([]byte, error) {
+	msg, exists := a.partial[chunk.GetId()]
+	if !exists {
+		if chunk.GetIndex() != 0 || chunk.GetTotal() < 2 {
+			return nil, fmt.Errorf("unexpected chunk %d/%d of message %d", chunk.GetIndex(), chunk.GetTotal(), chunk.GetId())
+		}
+		msg = &partialMessage{total: chunk.GetTotal()}
+		a.partial[chunk.GetId()] = msg
+	}
+
+	if chunk.GetIndex() != msg.next || chunk.GetTotal() != msg.total {
+		a.drop(chunk.GetId())
+		return nil, fmt.Errorf("out of order chunk %d/%d of message %d", chunk.GetIndex(), chunk.GetTotal(), chunk.GetId())
+	}
+
+	size := int64(len(chunk.GetData()))
+	if int64(len(msg.data))+size > a.maxSize || a.pending+size > a.maxSize {
+		a.drop(chunk.GetId())
+		return nil, fmt.Errorf("%w: message %d exceeds %d bytes", ErrMessageTooLarge, chunk.GetId(), a.maxSize)
+	}
+
+	msg.data = append(msg.data, chunk.GetData()...)
+	msg.next++
+	a.pending += size
+
+	if msg.next < msg.total {
+		return nil, nil
+	}
+	a.drop(chunk.GetId())
+	return msg.data, nil
+}
+
+func (a *assembler) drop(id uint64) {
+	if msg, exists := a.partial[id]; exists {
+		a.pending -= int64(len(msg.data))
+		delete(a.partial, id)
+	}
+}
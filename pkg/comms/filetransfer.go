@@ -0,0 +1,622 @@
+package comms
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/eglochon/simple-lan-messaging/models"
+)
+
+// fileChunkSize is the amount of file data carried by each FileChunk
+const fileChunkSize = 32 << 10
+
+// maxPendingOffers is how many offers a peer may have waiting for an answer
+const maxPendingOffers = 16
+
+// errChecksumMismatch means the received data is wrong and can't be resumed
+var errChecksumMismatch = errors.New("SHA-256 mismatch")
+
+// FileDirection tells whether a transfer is outgoing or incoming
+type FileDirection int
+
+const (
+	FileSending FileDirection = iota
+	FileReceiving
+)
+
+func (d FileDirection) String() string {
+	if d == FileSending {
+		return "sending"
+	}
+	return "receiving"
+}
+
+// FileTransfer is a snapshot of a file transfer's progress
+type FileTransfer struct {
+	ID          string
+	PeerID      string
+	Name        string
+	Size        uint64
+	Direction   FileDirection
+	Transferred uint64
+	Accepted    bool
+	Done        bool
+	Path        string // local source or destination, once known
+	Err         error  // set when the transfer failed or was interrupted
+}
+
+type outgoingFile struct {
+	FileTransfer
+	sum       []byte
+	streaming bool
+	resumeAt  *uint64 // offset the receiver asked for while streaming
+}
+
+type incomingFile struct {
+	FileTransfer
+	sum       []byte
+	partPath  string
+	file      *os.File
+	hash      hash.Hash // of the data in file, so the end needs no second pass
+	requested uint64    // offset of the last FileAccept sent
+}
+
+// fileTransfers holds the state of all transfers, keyed by peer ID and transfer ID
+type fileTransfers struct {
+	mu       sync.Mutex
+	outgoing map[string]*outgoingFile
+	incoming map[string]*incomingFile
+}
+
+func newFileTransfers() *fileTransfers {
+	return &fileTransfers{
+		outgoing: make(map[string]*outgoingFile),
+		incoming: make(map[string]*incomingFile),
+	}
+}
+
+func transferKey(peerID, id string) string {
+	return peerID + "/" + id
+}
+
+// OnFileOffer registers a callback for incoming file offers awaiting AcceptFile or RejectFile.
+func (pm *PeerManager) OnFileOffer(fn func(offer FileTransfer)) {
+	pm.onFileOffer = fn
+}
+
+// OnFileProgress registers a callback for progress, completion and failure of transfers.
+func (pm *PeerManager) OnFileProgress(fn func(progress FileTransfer)) {
+	pm.onFileProgress = fn
+}
+
+// Transfers returns a snapshot of all known file transfers
+func (pm *PeerManager) Transfers() []FileTransfer {
+	pm.files.mu.Lock()
+	defer pm.files.mu.Unlock()
+
+	var list []FileTransfer
+	for _, out := range pm.files.outgoing {
+		list = append(list, out.FileTransfer)
+	}
+	for _, in := range pm.files.incoming {
+		list = append(list, in.FileTransfer)
+	}
+	return list
+}
+
+// SendFile offers the file at path to a peer; data flows once the peer accepts.
+func (pm *PeerManager) SendFile(peerID, path string) (FileTransfer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return FileTransfer{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return FileTransfer{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return FileTransfer{}, fmt.Errorf("%s is not a regular file", path)
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return FileTransfer{}, err
+	}
+	sum := h.Sum(nil)
+
+	out := &outgoingFile{
+		FileTransfer: FileTransfer{
+			ID:        hex.EncodeToString(sum[:8]),
+			PeerID:    peerID,
+			Name:      filepath.Base(path),
+			Size:      uint64(info.Size()),
+			Direction: FileSending,
+			Path:      path,
+		},
+		sum: sum,
+	}
+
+	pm.files.mu.Lock()
+	pm.files.outgoing[transferKey(peerID, out.ID)] = out
+	snapshot := out.FileTransfer
+	pm.files.mu.Unlock()
+
+	return snapshot, pm.sendOffer(out)
+}
+
+// AcceptFile accepts a pending offer, storing the file in dir. A partial
+// download left in dir by an earlier attempt is resumed.
+func (pm *PeerManager) AcceptFile(peerID, id, dir string) error {
+	key := transferKey(peerID, id)
+	pm.files.mu.Lock()
+	in, exists := pm.files.incoming[key]
+	if !exists {
+		pm.files.mu.Unlock()
+		return fmt.Errorf("no file offer %s from %s", id, peerID)
+	}
+	if in.file == nil {
+		// Hashing what an earlier attempt left takes a while, so the partial
+		// download is opened without holding up other transfers
+		name, size := in.Name, in.Size
+		pm.files.mu.Unlock()
+		part, err := openPart(dir, name, id, size)
+		if err != nil {
+			return err
+		}
+		pm.files.mu.Lock()
+		if pm.files.incoming[key] != in {
+			pm.files.mu.Unlock()
+			part.file.Close()
+			return fmt.Errorf("file offer %s from %s was withdrawn", id, peerID)
+		}
+		if in.file == nil {
+			in.partPath, in.file, in.hash, in.Transferred = part.path, part.file, part.hash, part.size
+		} else {
+			// Accepted twice at once, the other call opened it first
+			part.file.Close()
+		}
+	}
+	in.Accepted = true
+	in.Err = nil
+	if in.Transferred == in.Size {
+		// Nothing left to send, as for an empty file or a download that was
+		// complete but not yet verified
+		snapshot, err := pm.settleIncoming(in, nil)
+		pm.files.mu.Unlock()
+		pm.endIncoming(snapshot, err)
+		return nil
+	}
+	offset := in.Transferred
+	in.requested = offset
+	pm.files.mu.Unlock()
+
+	return pm.sendAccept(peerID, id, offset)
+}
+
+func (pm *PeerManager) sendAccept(peerID, id string, offset uint64) error {
+	return pm.Send(peerID, &models.Envelope{
+		Type:    "file_accept",
+		Payload: &models.Envelope_FileAccept{FileAccept: &models.FileAccept{Id: id, Offset: offset}},
+	})
+}
+
+// RejectFile declines a pending offer.
+func (pm *PeerManager) RejectFile(peerID, id, reason string) error {
+	pm.files.mu.Lock()
+	in, exists := pm.files.incoming[transferKey(peerID, id)]
+	if exists {
+		in.close()
+		delete(pm.files.incoming, transferKey(peerID, id))
+	}
+	pm.files.mu.Unlock()
+
+	if !exists {
+		return fmt.Errorf("no file offer %s from %s", id, peerID)
+	}
+	return pm.Send(peerID, &models.Envelope{
+		Type:    "file_reject",
+		Payload: &models.Envelope_FileReject{FileReject: &models.FileReject{Id: id, Reason: reason}},
+	})
+}
+
+func (pm *PeerManager) sendOffer(out *outgoingFile) error {
+	return pm.sendFeature(out.PeerID, FeatureFileTransfer, &models.Envelope{
+		Type: "file_offer",
+		Payload: &models.Envelope_FileOffer{
+			FileOffer: &models.FileOffer{Id: out.ID, Name: out.Name, Size: out.Size, Sha256: out.sum},
+		},
+	})
+}
+
+// resumeTransfers re-offers unfinished outgoing files after a peer reconnects
+func (pm *PeerManager) resumeTransfers(peerID string) {
+	pm.files.mu.Lock()
+	var pending []*outgoingFile
+	for _, out := range pm.files.outgoing {
+		if out.PeerID == peerID && out.Accepted && !out.streaming {
+			pending = append(pending, out)
+		}
+	}
+	pm.files.mu.Unlock()
+
+	for _, out := range pending {
+		if err := pm.sendOffer(out); err != nil {
+			log.Printf("[WARN] Failed to resume transfer %s to %s: %v", out.ID, peerID, err)
+		}
+	}
+}
+
+// handleFileEnvelope processes the file transfer family and reports whether env belonged to it
+func (pm *PeerManager) handleFileEnvelope(peerID string, env *models.Envelope) bool {
+	switch payload := env.Payload.(type) {
+	case *models.Envelope_FileOffer:
+		pm.handleFileOffer(peerID, payload.FileOffer)
+	case *models.Envelope_FileAccept:
+		pm.handleFileAccept(peerID, payload.FileAccept)
+	case *models.Envelope_FileReject:
+		pm.finishOutgoing(peerID, payload.FileReject.GetId(), fmt.Errorf("rejected: %s", payload.FileReject.GetReason()))
+	case *models.Envelope_FileChunk:
+		pm.handleFileChunk(peerID, payload.FileChunk)
+	case *models.Envelope_FileComplete:
+		var err error
+		if !payload.FileComplete.GetOk() {
+			err = fmt.Errorf("receiver failed: %s", payload.FileComplete.GetError())
+		}
+		pm.finishOutgoing(peerID, payload.FileComplete.GetId(), err)
+	default:
+		return false
+	}
+	return true
+}
+
+func (pm *PeerManager) handleFileOffer(peerID string, offer *models.FileOffer) {
+	name := filepath.Base(offer.GetName())
+	_, idErr := hex.DecodeString(offer.GetId())
+	if name == "." || name == ".." || name == string(filepath.Separator) || idErr != nil ||
+		offer.GetId() == "" || len(offer.GetSha256()) != sha256.Size {
+		log.Printf("[WARN] Ignored invalid file offer from %s", peerID)
+		return
+	}
+
+	key := transferKey(peerID, offer.GetId())
+	pm.files.mu.Lock()
+	in, exists := pm.files.incoming[key]
+	if exists && in.Accepted && bytes.Equal(in.sum, offer.GetSha256()) {
+		// The sender is resuming a transfer we already accepted
+		dir := filepath.Dir(in.partPath)
+		pm.files.mu.Unlock()
+		if err := pm.AcceptFile(peerID, offer.GetId(), dir); err != nil {
+			log.Printf("[WARN] Failed to resume transfer %s from %s: %v", offer.GetId(), peerID, err)
+		}
+		return
+	}
+	if exists {
+		in.close()
+	} else if pm.pendingOffers(peerID) >= maxPendingOffers {
+		pm.files.mu.Unlock()
+		log.Printf("[WARN] Rejected file offer from %s: too many pending offers", peerID)
+		if err := pm.Send(peerID, &models.Envelope{
+			Type:    "file_reject",
+			Payload: &models.Envelope_FileReject{FileReject: &models.FileReject{Id: offer.GetId(), Reason: "too many pending offers"}},
+		}); err != nil {
+			log.Printf("[WARN] Failed to reject file offer %s from %s: %v", offer.GetId(), peerID, err)
+		}
+		return
+	}
+	in = &incomingFile{
+		FileTransfer: FileTransfer{
+			ID:        offer.GetId(),
+			PeerID:    peerID,
+			Name:      name,
+			Size:      offer.GetSize(),
+			Direction: FileReceiving,
+		},
+		sum: offer.GetSha256(),
+	}
+	pm.files.incoming[key] = in
+	snapshot := in.FileTransfer
+	pm.files.mu.Unlock()
+
+	if pm.onFileOffer != nil {
+		pm.onFileOffer(snapshot)
+	}
+}
+
+// pendingOffers counts the offers from peerID not accepted yet. Called with
+// files.mu held.
+func (pm *PeerManager) pendingOffers(peerID string) int {
+	n := 0
+	for _, in := range pm.files.incoming {
+		if in.PeerID == peerID && !in.Accepted {
+			n++
+		}
+	}
+	return n
+}
+
+func (pm *PeerManager) handleFileAccept(peerID string, accept *models.FileAccept) {
+	pm.files.mu.Lock()
+	out, exists := pm.files.outgoing[transferKey(peerID, accept.GetId())]
+	if !exists || accept.GetOffset() > out.Size {
+		pm.files.mu.Unlock()
+		return
+	}
+	if out.streaming {
+		// Data in flight got lost, continue from where the receiver is
+		offset := accept.GetOffset()
+		out.resumeAt = &offset
+		pm.files.mu.Unlock()
+		return
+	}
+	out.Accepted = true
+	out.streaming = true
+	out.Transferred = accept.GetOffset()
+	out.Err = nil
+	pm.files.mu.Unlock()
+
+	pm.spawn(func() { pm.streamFile(out, accept.GetOffset()) })
+}
+
+// streamFile sends the file from offset in FileChunk envelopes, moving to
+// another offset when the receiver asks for it
+func (pm *PeerManager) streamFile(out *outgoingFile, offset uint64) {
+	err := func() error {
+		file, err := os.Open(out.Path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		buf := make([]byte, fileChunkSize)
+		for {
+			pm.files.mu.Lock()
+			if out.resumeAt != nil {
+				offset = *out.resumeAt
+				out.resumeAt = nil
+			}
+			if offset >= out.Size {
+				out.streaming = false
+				pm.files.mu.Unlock()
+				return nil
+			}
+			pm.files.mu.Unlock()
+
+			want := min(uint64(len(buf)), out.Size-offset)
+			n, err := file.ReadAt(buf[:want], int64(offset))
+			if uint64(n) < want {
+				return fmt.Errorf("reading %s: %w", out.Path, err)
+			}
+			err = pm.Send(out.PeerID, &models.Envelope{
+				Type: "file_chunk",
+				Payload: &models.Envelope_FileChunk{
+					FileChunk: &models.FileChunk{Id: out.ID, Offset: offset, Data: buf[:n]},
+				},
+			})
+			if err != nil {
+				return fmt.Errorf("interrupted: %w", err)
+			}
+			offset += uint64(n)
+
+			pm.files.mu.Lock()
+			out.Transferred = offset
+			snapshot := out.FileTransfer
+			pm.files.mu.Unlock()
+			pm.reportFileProgress(snapshot)
+		}
+	}()
+	if err == nil {
+		return
+	}
+
+	pm.files.mu.Lock()
+	out.streaming = false
+	out.resumeAt = nil
+	out.Err = err
+	snapshot := out.FileTransfer
+	pm.files.mu.Unlock()
+
+	pm.reportFileProgress(snapshot)
+}
+
+// finishOutgoing ends an outgoing transfer once the receiver reported back
+func (pm *PeerManager) finishOutgoing(peerID, id string, err error) {
+	pm.files.mu.Lock()
+	out, exists := pm.files.outgoing[transferKey(peerID, id)]
+	if !exists {
+		pm.files.mu.Unlock()
+		return
+	}
+	delete(pm.files.outgoing, transferKey(peerID, id))
+	out.Done = true
+	out.Err = err
+	snapshot := out.FileTransfer
+	pm.files.mu.Unlock()
+
+	pm.reportFileProgress(snapshot)
+}
+
+func (pm *PeerManager) handleFileChunk(peerID string, chunk *models.FileChunk) {
+	key := transferKey(peerID, chunk.GetId())
+	pm.files.mu.Lock()
+	in, exists := pm.files.incoming[key]
+	if !exists || !in.Accepted || in.file == nil {
+		pm.files.mu.Unlock()
+		return
+	}
+
+	data := chunk.GetData()
+	switch {
+	case chunk.GetOffset() < in.Transferred:
+		// Sent again after we asked the sender to go back
+		pm.files.mu.Unlock()
+		return
+	case chunk.GetOffset() > in.Transferred:
+		// Chunks were lost, most likely on a connection that died. Ask the
+		// sender to continue from what we have, once for each gap.
+		offset := in.Transferred
+		ask := in.requested != offset
+		in.requested = offset
+		pm.files.mu.Unlock()
+		if ask {
+			if err := pm.sendAccept(peerID, in.ID, offset); err != nil {
+				log.Printf("[WARN] Failed to resume transfer %s from %s: %v", in.ID, peerID, err)
+			}
+		}
+		return
+	}
+
+	var err error
+	if in.Transferred+uint64(len(data)) > in.Size {
+		err = fmt.Errorf("data exceeds announced size of %d bytes", in.Size)
+	} else if _, err = in.file.Write(data); err == nil {
+		in.hash.Write(data)
+		in.Transferred += uint64(len(data))
+	}
+
+	if err == nil && in.Transferred < in.Size {
+		snapshot := in.FileTransfer
+		pm.files.mu.Unlock()
+		pm.reportFileProgress(snapshot)
+		return
+	}
+	snapshot, err := pm.settleIncoming(in, err)
+	pm.files.mu.Unlock()
+	pm.endIncoming(snapshot, err)
+}
+
+// settleIncoming ends an incoming transfer, verifying the file unless err
+// already failed it. Only data that fails verification is discarded, a
+// partial download is kept for another attempt. Called with files.mu held.
+func (pm *PeerManager) settleIncoming(in *incomingFile, err error) (FileTransfer, error) {
+	if err == nil {
+		err = in.finish()
+	}
+	if err != nil {
+		in.close()
+		if errors.Is(err, errChecksumMismatch) {
+			os.Remove(in.partPath)
+		}
+	}
+	in.Done = true
+	in.Err = err
+	delete(pm.files.incoming, transferKey(in.PeerID, in.ID))
+	return in.FileTransfer, err
+}
+
+// endIncoming reports the end of an incoming transfer locally and to the sender
+func (pm *PeerManager) endIncoming(snapshot FileTransfer, err error) {
+	pm.reportFileProgress(snapshot)
+
+	complete := &models.FileComplete{Id: snapshot.ID, Ok: err == nil}
+	if err != nil {
+		complete.Error = err.Error()
+	}
+	if err := pm.Send(snapshot.PeerID, &models.Envelope{
+		Type:    "file_complete",
+		Payload: &models.Envelope_FileComplete{FileComplete: complete},
+	}); err != nil {
+		log.Printf("[WARN] Failed to confirm transfer %s to %s: %v", snapshot.ID, snapshot.PeerID, err)
+	}
+}
+
+func (pm *PeerManager) reportFileProgress(progress FileTransfer) {
+	if pm.onFileProgress != nil {
+		pm.onFileProgress(progress)
+	}
+}
+
+// partFile is a partial download opened for appending
+type partFile struct {
+	path string
+	file *os.File
+	hash hash.Hash // of the size bytes already in file
+	size uint64
+}
+
+// openPart opens the partial download of a file in dir, keeping data from
+// earlier attempts unless it is longer than the whole file
+func openPart(dir, name, id string, limit uint64) (*partFile, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf(".%s.%s.part", name, id))
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	size := uint64(info.Size())
+	if size > limit {
+		size = 0
+	}
+	if err := file.Truncate(int64(size)); err != nil {
+		file.Close()
+		return nil, err
+	}
+	// Reading the kept data leaves the file positioned to append
+	h := sha256.New()
+	if _, err := io.CopyN(h, file, int64(size)); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &partFile{path: path, file: file, hash: h, size: size}, nil
+}
+
+// finish verifies the downloaded data and moves it to its final name
+func (in *incomingFile) finish() error {
+	in.close()
+	if !bytes.Equal(in.hash.Sum(nil), in.sum) {
+		return errChecksumMismatch
+	}
+
+	dest, err := uniquePath(filepath.Join(filepath.Dir(in.partPath), in.Name))
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(in.partPath, dest); err != nil {
+		return err
+	}
+	in.Path = dest
+	return nil
+}
+
+func (in *incomingFile) close() {
+	if in.file != nil {
+		in.file.Close()
+		in.file = nil
+	}
+}
+
+// uniquePath returns path, or path with a numeric suffix if it already exists
+func uniquePath(path string) (string, error) {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 0; i < 1000; i++ {
+		candidate := path
+		if i > 0 {
+			candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+		}
+		if _, err := os.Stat(candidate); errors.Is(err, os.ErrNotExist) {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("no free file name for %s", path)
+}
@@ -0,0 +1,76 @@
+package comms
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Role labels keep a signature or key made for one side from being replayed
+// as the other side's
+const (
+	roleInitiator = "initiator"
+	roleResponder = "responder"
+)
+
+// handshakeLabel separates handshake hashes and signatures from any other use of the keys
+const handshakeLabel = "slm-handshake-v2"
+
+// handshakeTranscript hashes both Handshake messages exactly as they were sent
+func handshakeTranscript(initiatorHello, responderHello []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(handshakeLabel))
+	for _, msg := range [][]byte{initiatorHello, responderHello} {
+		h.Write(binary.BigEndian.AppendUint32(nil, uint32(len(msg))))
+		h.Write(msg)
+	}
+	return h.Sum(nil)
+}
+
+// authMessage is what each side signs to prove its identity
+func authMessage(role string, transcript []byte) []byte {
+	msg := []byte(handshakeLabel + " " + role + " auth\x00")
+	return append(msg, transcript...)
+}
+
+// deriveSessionKeys expands the ECDH secret into one key per direction,
+// salted with the transcript so every session gets fresh keys
+func deriveSessionKeys(sharedSecret, transcript []byte) (initiatorKey, responderKey []byte, err error) {
+	if initiatorKey, err = expandKey(sharedSecret, transcript, roleInitiator); err != nil {
+		return nil, nil, err
+	}
+	if responderKey, err = expandKey(sharedSecret, transcript, roleResponder); err != nil {
+		return nil, nil, err
+	}
+	return initiatorKey, responderKey, nil
+}
+
+// expandKey derives the key protecting the frames sent by role
+func expandKey(sharedSecret, transcript []byte, role string) ([]byte, error) {
+	key := make([]byte, 32)
+	r := hkdf.New(sha256.New, sharedSecret, transcript, []byte(handshakeLabel+" "+role+" key"))
+	if _, err := io.ReadFull(r, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// counterNonce turns a frame counter into a GCM nonce; with one key per
+// direction a counter value is never reused under the same key
+func counterNonce(seq uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], seq)
+	return nonce
+}
@@ -0,0 +1,25 @@
+package comms
+
+import (
+	"log"
+
+	"github.com/eglochon/simple-lan-messaging/models"
+	"github.com/eglochon/simple-lan-messaging/pkg/history"
+)
+
+// UseHistory records every direct and topic message sent or received,
+// whichever API sent it.
+func (pm *PeerManager) UseHistory(store *history.Store) {
+	pm.history = store
+}
+
+// record stores a message envelope in the history, reporting but not
+// failing on errors. Other envelopes are not recorded.
+func (pm *PeerManager) record(dir history.Direction, peerID string, env *models.Envelope) {
+	if pm.history == nil || env.GetMessage() == nil {
+		return
+	}
+	if err := pm.history.Add(dir, peerID, env); err != nil {
+		log.Printf("[WARN] Failed to save message: %v", err)
+	}
+}
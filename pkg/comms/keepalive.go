@@ -0,0 +1,154 @@
+package comms
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// KeepalivePolicy decides how often an idle connection is probed and how
+// long it may stay silent before it counts as dead. A zero Interval disables
+// keepalives.
+type KeepalivePolicy struct {
+	Interval time.Duration // time between pings
+	Timeout  time.Duration // extra time allowed for the pong
+}
+
+// DefaultKeepalivePolicy notices a dead connection within half a minute
+var DefaultKeepalivePolicy = KeepalivePolicy{
+	Interval: 15 * time.Second,
+	Timeout:  10 * time.Second,
+}
+
+// deadline is how long a connection may go without receiving any frame
+func (p KeepalivePolicy) deadline() time.Duration {
+	return p.Interval + p.Timeout
+}
+
+// SetKeepalivePolicy changes how connections attached from now on are probed.
+func (pm *PeerManager) SetKeepalivePolicy(policy KeepalivePolicy) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.keepalivePolicy = policy
+}
+
+// keepalive pings conn until it is closed or retired and copies the measured
+// round-trip time to the peer. A connection that stays silent past the
+// policy's deadline fails its pending read, which ends the read loop.
+func (pm *PeerManager) keepalive(peer *Peer, conn *SecureConn, policy KeepalivePolicy) {
+	if policy.Interval <= 0 || !conn.HasFeature(FeatureKeepalive) {
+		return
+	}
+	conn.startKeepalive(policy)
+
+	ticker := time.NewTicker(policy.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-conn.closed:
+			return
+		case <-pm.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if conn.retired.Load() {
+			return
+		}
+
+		if rtt := conn.RTT(); rtt > 0 {
+			pm.mu.Lock()
+			if peer.Conn == conn {
+				peer.RTT = rtt
+			}
+			pm.mu.Unlock()
+		}
+		if err := conn.Ping(); err != nil {
+			log.Printf("[WARN] Keepalive to %s failed: %v", peer.ID, err)
+			return
+		}
+	}
+}
+
+// startKeepalive arms the read deadline that detects a dead connection
+func (sc *SecureConn) startKeepalive(policy KeepalivePolicy) {
+	sc.keepaliveDeadline.Store(int64(policy.deadline()))
+	sc.extendDeadline()
+}
+
+// extendDeadline pushes the read deadline forward after a frame arrived
+func (sc *SecureConn) extendDeadline() {
+	deadline := time.Duration(sc.keepaliveDeadline.Load())
+	if deadline <= 0 || sc.retired.Load() {
+		return
+	}
+	sc.conn.SetReadDeadline(time.Now().Add(deadline))
+}
+
+// Ping sends a ping frame; the round-trip time is available from RTT once
+// the pong arrives.
+func (sc *SecureConn) Ping() error {
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+
+	sc.pingID++
+	payload := binary.BigEndian.AppendUint64(nil, sc.pingID)
+	sc.pingSent.Store(&pendingPing{id: sc.pingID, sent: time.Now()})
+	return sc.writeFrame(framePing, payload)
+}
+
+// RTT returns the round-trip time measured by the latest answered ping, or
+// zero if none was answered yet.
+func (sc *SecureConn) RTT() time.Duration {
+	return time.Duration(sc.rtt.Load())
+}
+
+// pendingPing is the last ping waiting for its pong
+type pendingPing struct {
+	id   uint64
+	sent time.Time
+}
+
+// handlePing leaves the pong for the connection's writer, so the reader
+// never blocks on a write. Only the latest ping needs an answer, a newer one
+// replaces a pong still waiting.
+func (sc *SecureConn) handlePing(payload []byte) {
+	sc.pong.Store(&payload)
+	select {
+	case sc.pongReady <- struct{}{}:
+	default:
+	}
+}
+
+// writePong answers the latest ping, if one is waiting
+func (sc *SecureConn) writePong() error {
+	payload := sc.pong.Swap(nil)
+	if payload == nil {
+		return nil
+	}
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+	return sc.writeFrame(framePong, *payload)
+}
+
+// handlePong records the round-trip time if the pong answers our last ping
+func (sc *SecureConn) handlePong(payload []byte) error {
+	if len(payload) != 8 {
+		return fmt.Errorf("invalid pong of %d bytes", len(payload))
+	}
+	ping := sc.pingSent.Load()
+	if ping == nil || ping.id != binary.BigEndian.Uint64(payload) {
+		// An answer to an older ping, the newer one is still on its way
+		return nil
+	}
+	sc.rtt.Store(int64(time.Since(ping.sent)))
+	return nil
+}
+
+// isTimeout reports whether err comes from a read deadline, as set by
+// keepalives on a connection that went silent
+func isTimeout(err error) bool {
+	var netErr interface{ Timeout() bool }
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -1,15 +1,21 @@
 package comms
 
 import (
+	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"log"
 	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/eglochon/simple-lan-messaging/models"
+	"github.com/eglochon/simple-lan-messaging/pkg/contacts"
+	"github.com/eglochon/simple-lan-messaging/pkg/discovery"
+	"github.com/eglochon/simple-lan-messaging/pkg/history"
 	"github.com/eglochon/simple-lan-messaging/pkg/identity"
 	"google.golang.org/protobuf/proto"
 )
@@ -17,123 +23,346 @@ import (
 type PeerManager struct {
 	self *identity.Identity
 
-	mu      sync.RWMutex
-	running bool
-	peers   map[string]*Peer // peerID → *Peer
+	mu    sync.RWMutex
+	peers map[string]*Peer         // peerID → *Peer
+	conns map[*SecureConn]struct{} // every connection with a running read loop
 
-	onMessage          func(peerID string, envelop *models.Envelope)
-	onPeerDisconnected func(peerID string)
+	ctx    context.Context // cancelled by Close or the context given to Start
+	cancel context.CancelFunc
+	lifeMu sync.Mutex     // orders spawn against Close
+	wg     sync.WaitGroup // goroutines Close waits for
+
+	onMessage      func(peerID string, envelop *models.Envelope)
+	onReceive      func(peerID string, envelop *models.Envelope)
+	onConnState    func(peerID string, state ConnState)
+	onFileOffer    func(offer FileTransfer)
+	onFileProgress func(progress FileTransfer)
+	onReceipt      func(peerID string, receipt *models.Receipt)
+	onPeerJoined   func(peerID string)
+	onPeerLeft     func(peerID string)
+	onSecurity     func(peerID string, err error)
+
+	warnMu   sync.Mutex
+	warnedAt map[string]time.Time // peerID → last security warning
+
+	subMu sync.RWMutex
+	subs  map[string][]TopicHandler // topic pattern → handlers
+
+	supMu       sync.Mutex
+	supervisors map[string]*supervisor // peerID → redial loop
+
+	contacts *contacts.Store // optional key pinning, nil accepts any peer
+	history  *history.Store  // optional message log
+
+	maxMessageSize  atomic.Int64
+	chunkSeq        atomic.Uint64
+	rekeyPolicy     RekeyPolicy
+	keepalivePolicy KeepalivePolicy
+	reconnectPolicy ReconnectPolicy
+	presencePolicy  PresencePolicy
+
+	files *fileTransfers
+
+	outbox       *Outbox // optional queue for unreachable peers
+	onOutboxSent func(entry OutboxEntry)
 }
 
 // [NewPeerManager] creates a new peer manager for "self"
 func NewPeerManager(self *identity.Identity) *PeerManager {
-	return &PeerManager{
-		self:    self,
-		running: true,
-		peers:   make(map[string]*Peer),
+	pm := &PeerManager{
+		self:  self,
+		peers: make(map[string]*Peer),
+		conns: make(map[*SecureConn]struct{}),
+		subs:  make(map[string][]TopicHandler),
+		files: newFileTransfers(),
+
+		warnedAt: make(map[string]time.Time),
+
+		supervisors: make(map[string]*supervisor),
+
+		rekeyPolicy:     DefaultRekeyPolicy,
+		keepalivePolicy: DefaultKeepalivePolicy,
+		reconnectPolicy: DefaultReconnectPolicy,
+		presencePolicy:  DefaultPresencePolicy,
+	}
+	pm.ctx, pm.cancel = context.WithCancel(context.Background())
+	context.AfterFunc(pm.ctx, func() { pm.closeConns() })
+	pm.maxMessageSize.Store(DefaultMaxMessageSize)
+	return pm
+}
+
+// [UseContacts] enables key pinning and blocking against the given contact store.
+func (pm *PeerManager) UseContacts(store *contacts.Store) {
+	pm.contacts = store
+}
+
+// [Start] ties the manager to ctx: cancelling it closes all connections and
+// stops background work, as Close does, without waiting.
+func (pm *PeerManager) Start(ctx context.Context) error {
+	if err := pm.ctx.Err(); err != nil {
+		return ErrClosed
+	}
+	context.AfterFunc(ctx, pm.cancel)
+	return nil
+}
+
+// [Close] closes all connections and waits for every read loop, writer,
+// supervisor and other background goroutine of the manager to exit.
+func (pm *PeerManager) Close() error {
+	pm.lifeMu.Lock()
+	pm.cancel()
+	pm.lifeMu.Unlock()
+
+	err := pm.closeConns()
+	pm.wg.Wait()
+	return err
+}
+
+// [closeConns] closes every connection, unblocking their read loops.
+func (pm *PeerManager) closeConns() error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	var errs []error
+	for conn := range pm.conns {
+		if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
 	}
+	return errors.Join(errs...)
 }
 
-// [Stop] stops all running read-loops of connections
-func (pm *PeerManager) Stop() {
-	pm.running = false
+// [spawn] runs fn in a goroutine that Close waits for. It reports false and
+// does nothing once the manager is closed.
+func (pm *PeerManager) spawn(fn func()) bool {
+	pm.lifeMu.Lock()
+	defer pm.lifeMu.Unlock()
+
+	if pm.ctx.Err() != nil {
+		return false
+	}
+	pm.wg.Add(1)
+	go func() {
+		defer pm.wg.Done()
+		fn()
+	}()
+	return true
 }
 
-// [AllPeers] returns a slice of all known peers
-func (pm *PeerManager) AllPeers() []*Peer {
+// [AllPeers] returns a snapshot of all known peers
+func (pm *PeerManager) AllPeers() []PeerInfo {
 	pm.mu.RLock()
 	defer pm.mu.RUnlock()
 
-	var list []*Peer
+	var list []PeerInfo
 	for _, peer := range pm.peers {
-		list = append(list, peer)
+		list = append(list, peer.info())
 	}
 	return list
 }
 
-// [RegisterDiscovery] handles incoming discovery messages and registers or updates peers.
-func (pm *PeerManager) RegisterDiscovery(msg *models.Discovery, addr net.Addr) error {
+// [Lookup] resolves a peer by exact ID, display name or unambiguous ID prefix
+// and returns a snapshot of it.
+func (pm *PeerManager) Lookup(ref string) (PeerInfo, error) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	if peer, exists := pm.peers[ref]; exists {
+		return peer.info(), nil
+	}
+
+	var matches []*Peer
+	for _, peer := range pm.peers {
+		if strings.EqualFold(peer.Name, ref) || strings.HasPrefix(peer.ID, ref) {
+			matches = append(matches, peer)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return PeerInfo{}, fmt.Errorf("%w: %s", ErrPeerNotFound, ref)
+	case 1:
+		return matches[0].info(), nil
+	default:
+		return PeerInfo{}, fmt.Errorf("ambiguous peer %q matches %d peers", ref, len(matches))
+	}
+}
+
+// [RemovePeer] closes any connection to a peer and forgets it.
+func (pm *PeerManager) RemovePeer(peerID string) {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 
-	var encPubKey [32]byte
-	var peerIP string
-	peerPort := uint16(0)
+	peer, exists := pm.peers[peerID]
+	if !exists {
+		return
+	}
+	if peer.Conn != nil {
+		peer.Conn.Close()
+		peer.Conn = nil
+	}
+	delete(pm.peers, peerID)
+}
 
+// [RegisterDiscovery] handles incoming discovery messages and registers or updates peers.
+func (pm *PeerManager) RegisterDiscovery(msg *models.Discovery, addr net.Addr) error {
 	peerID := msg.GetId()
 
+	// Signature checks and the contact store, which may write to disk, run
+	// before taking pm.mu so they don't hold up sends and read loops
+
+	// Only trust announcements signed by the key they claim, from an address they list
+	if err := discovery.VerifyAnnouncement(msg, time.Now()); err != nil {
+		return fmt.Errorf("rejected announcement for %s: %w", peerID, err)
+	}
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return errors.New("could not parse IP addr")
+	}
+	if err := discovery.VerifySource(msg, udpAddr.IP); err != nil {
+		return fmt.Errorf("rejected announcement for %s: %w", peerID, err)
+	}
+
 	// Decode base64-encoded EncPubKey string
-	encPubKeyStr := msg.GetEnc()
-	encPubKeyBytes, err := base64.RawURLEncoding.DecodeString(encPubKeyStr)
-	if err == nil && len(encPubKeyBytes) == 32 {
-		copy(encPubKey[:], encPubKeyBytes)
-	} else {
+	var encPubKey [32]byte
+	encPubKeyBytes, err := base64.RawURLEncoding.DecodeString(msg.GetEnc())
+	if err != nil || len(encPubKeyBytes) != 32 {
 		log.Printf("[WARN] Invalid EncPubKey from peer %s at %s: %v (IGNORED)", peerID, addr.String(), err)
+		return fmt.Errorf("invalid EncPubKey from peer %s", peerID)
+	}
+	copy(encPubKey[:], encPubKeyBytes)
+
+	// Pin the key to its name and refuse blocked or impersonating peers
+	if pm.contacts != nil {
+		if _, err := pm.contacts.Observe(peerID, msg.GetName()); err != nil {
+			var keyErr *contacts.KeyChangedError
+			var nameErr *contacts.NameChangedError
+			switch {
+			case errors.As(err, &keyErr) && !keyErr.Enforced:
+				pm.securityWarning(peerID, fmt.Errorf("%w; peer %s may be impersonating %q", err, peerID, keyErr.Name))
+			case errors.As(err, &nameErr):
+				pm.securityWarning(peerID, err)
+			default:
+				return fmt.Errorf("rejected announcement for %s: %w", peerID, err)
+			}
+		}
+	}
+
+	joined, moved, err := pm.updatePeer(msg, encPubKey, udpAddr.IP.String())
+	if err != nil {
 		return err
 	}
+	pm.emitPresence(joined)
+	if moved {
+		pm.notifyConnState(peerID, ConnDisconnected)
+		pm.wakeSupervisor(peerID)
+	}
 
-	// Update IP/port & last seen
-	if udpAddr, ok := addr.(*net.UDPAddr); ok {
-		peerIP = udpAddr.IP.String()
-		peerPort = uint16(msg.GetPort())
-	} else {
-		return errors.New("could not parse IP addr")
+	// The peer is back, deliver what we queued for it
+	if pm.outbox != nil && pm.outbox.hasPending(peerID) {
+		pm.spawn(func() { pm.flushOutbox(peerID) })
 	}
+	return nil
+}
+
+// [updatePeer] applies a verified announcement received from peerIP. It
+// reports whether the peer came online and whether its connection was
+// closed because it moved to another address.
+func (pm *PeerManager) updatePeer(msg *models.Discovery, encPubKey [32]byte, peerIP string) (joined *presenceEvent, moved bool, err error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
 
+	peerID := msg.GetId()
+	peerPort := uint16(msg.GetPort())
+
+	// Each announcement is accepted once. Copies of it, as sent by a peer on
+	// several of our networks or by someone replaying it, change nothing.
 	peer, exists := pm.peers[peerID]
+	if exists && msg.GetTimestamp() < peer.announcedAt {
+		return nil, false, fmt.Errorf("rejected announcement for %s: %w", peerID, discovery.ErrStaleAnnouncement)
+	}
+	if exists && msg.GetTimestamp() == peer.announcedAt {
+		return nil, false, fmt.Errorf("rejected announcement for %s: %w", peerID, discovery.ErrReplayedAnnouncement)
+	}
 	if !exists {
 		peer = &Peer{
 			ID:        peerID,
 			EncPubKey: encPubKey,
+			Name:      msg.GetName(),
 			IP:        peerIP,
 			Port:      peerPort,
-			LastSeen:  time.Now(),
 		}
 		pm.peers[peerID] = peer
 	} else {
 		peer.EncPubKey = encPubKey
-		peer.LastSeen = time.Now()
+		peer.Name = msg.GetName()
 
-		if peerIP != peer.IP || peerPort != peer.Port {
+		// A peer sharing several networks with us announces on each of them,
+		// keep the connected address for as long as it is still announced
+		inUse := peer.Conn != nil && time.Since(peer.addrSeenAt) < pm.presencePolicy.IdleAfter
+		if (peerIP != peer.IP && !inUse) || peerPort != peer.Port {
+			// A peer that only connected to us had no address to move from
+			known := peer.IP != ""
 			peer.IP = peerIP
 			peer.Port = peerPort
 
-			if peer.Conn != nil {
+			if known && peer.Conn != nil {
 				peer.Conn.Close()
 				peer.Conn = nil
+				peer.writer.close()
+				peer.writer = nil
+				moved = true
 			}
 		}
 	}
-	return nil
+	if peerIP == peer.IP {
+		peer.addrSeenAt = time.Now()
+	}
+	peer.Topics = msg.GetTopics()
+	peer.announcedAt = msg.GetTimestamp()
+	return pm.markSeen(peer), moved, nil
 }
 
 // [Connect] initiates a secure connection to a peer, performing handshake if needed.
 func (pm *PeerManager) Connect(peerID string) (*SecureConn, error) {
 	pm.mu.Lock()
 	peer, exists := pm.peers[peerID]
+	timeout := pm.reconnectPolicy.DialTimeout
 	pm.mu.Unlock()
 
 	if !exists {
-		return nil, errors.New("peer not found")
+		return nil, fmt.Errorf("%w: %s", ErrPeerNotFound, peerID)
 	}
-	if peer.Conn != nil {
+	if pm.isBlocked(peerID) {
+		return nil, contacts.ErrBlocked
+	}
+	if pm.ctx.Err() != nil {
+		return nil, ErrClosed
+	}
+
+	// Concurrent senders share one dial instead of racing each other
+	peer.dialMu.Lock()
+	defer peer.dialMu.Unlock()
+
+	pm.mu.RLock()
+	existing := peer.Conn
+	pm.mu.RUnlock()
+	if existing != nil {
 		// Already connected
-		return peer.Conn, nil
+		return existing, nil
 	}
 
-	conn, _, err := DialSecurePeer(peer.Addr(), pm.self)
+	conn, _, err := DialSecurePeerContext(pm.ctx, peer.Addr(), pm.self, timeout)
+	if pm.ctx.Err() != nil {
+		if err == nil {
+			conn.Close()
+		}
+		return nil, ErrClosed
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect: %w", err)
 	}
 
-	pm.mu.Lock()
-	peer.Conn = conn
-	pm.mu.Unlock()
-
-	// Start background read loop (optional)
-	go pm.readLoop(peer)
-
-	return conn, nil
+	return pm.attach(peer, conn), nil
 }
 
 // [Accept] accepts an inbound connection, performs handshake, and registers the peer.
@@ -143,18 +372,96 @@ func (pm *PeerManager) Accept(conn net.Conn) error {
 		return err
 	}
 
+	if pm.isBlocked(peerID) {
+		sc.Close()
+		return fmt.Errorf("refused connection from %s: %w", peerID, contacts.ErrBlocked)
+	}
+
+	pm.attach(pm.getOrAddPeer(peerID), sc)
+
+	return nil
+}
+
+// [isBlocked] reports whether the contact store blocks peerID.
+func (pm *PeerManager) isBlocked(peerID string) bool {
+	return pm.contacts != nil && pm.contacts.IsBlocked(peerID)
+}
+
+// [getOrAddPeer] returns the peer with the given ID, registering a bare entry if unknown.
+func (pm *PeerManager) getOrAddPeer(peerID string) *Peer {
 	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
 	peer, exists := pm.peers[peerID]
 	if !exists {
 		peer = &Peer{ID: peerID}
 		pm.peers[peerID] = peer
 	}
-	peer.Conn = sc
+	return peer
+}
+
+// [attach] binds an established connection to a peer and starts its read loop.
+// If the peer is already connected, only one of the two connections survives
+// (see [preferConn]) and the other is closed. Returns the surviving connection.
+func (pm *PeerManager) attach(peer *Peer, conn *SecureConn) *SecureConn {
+	pm.mu.Lock()
+	if pm.ctx.Err() != nil {
+		pm.mu.Unlock()
+		conn.Close()
+		return conn
+	}
+	pm.conns[conn] = struct{}{}
+	existing := peer.Conn
+	if existing != nil {
+		if !pm.preferConn(peer.ID, conn, existing) {
+			pm.mu.Unlock()
+			log.Printf("[INFO] Closing duplicate connection to %s", peer.ID)
+			conn.retire()
+			pm.spawn(func() { pm.readLoop(peer, conn, nil) })
+			return existing
+		}
+		log.Printf("[INFO] Replacing connection to %s", peer.ID)
+		peer.writer.close()
+		existing.retire()
+	}
+	conn.SetRekeyPolicy(pm.rekeyPolicy)
+	writer := newConnWriter(pm, conn)
+	peer.Conn = conn
+	peer.writer = writer
+	peer.RTT = 0
+	joined := pm.markSeen(peer)
+	keepalive := pm.keepalivePolicy
 	pm.mu.Unlock()
+	pm.emitPresence(joined)
+	if existing == nil {
+		pm.notifyConnState(peer.ID, ConnConnected)
+		pm.wakeSupervisor(peer.ID)
+	}
 
-	go pm.readLoop(peer)
+	pm.spawn(func() { pm.readLoop(peer, conn, writer) })
+	pm.spawn(func() { pm.keepalive(peer, conn, keepalive) })
 
-	return nil
+	// Let the peer know what we are interested in
+	if err := pm.sendSubscriptions(peer); err != nil {
+		log.Printf("[WARN] Failed to send subscriptions to %s: %v", peer.ID, err)
+	}
+
+	pm.spawn(func() { pm.resumeTransfers(peer.ID) })
+	pm.spawn(func() { pm.flushOutbox(peer.ID) })
+	return conn
+}
+
+// [preferConn] decides whether conn should replace the existing connection
+// to peerID. When both nodes dial each other at once, both keep the
+// connection dialed by the node with the lower ID, so they agree without
+// talking. A new connection in the same direction replaces the old one,
+// which is most likely dead.
+func (pm *PeerManager) preferConn(peerID string, conn, existing *SecureConn) bool {
+	if conn.initiator == existing.initiator {
+		return true
+	}
+	weDialLower := pm.self.GetID() < peerID
+	return conn.initiator == weDialLower
 }
 
 // [OnMessage] registers a callback that will be called on message receipt.
@@ -162,31 +469,116 @@ func (pm *PeerManager) OnMessage(fn func(peerID string, envelop *models.Envelope
 	pm.onMessage = fn
 }
 
+// [OnReceive] registers a callback for every application envelope received,
+// including topic messages that went to a subscription handler instead of OnMessage.
+func (pm *PeerManager) OnReceive(fn func(peerID string, envelop *models.Envelope)) {
+	pm.onReceive = fn
+}
+
 // [readLoop] continuously reads decrypted messages from a peer and calls the message handler.
-func (pm *PeerManager) readLoop(peer *Peer) {
-	for pm.running {
-		data, err := peer.Conn.ReadEncrypted()
+func (pm *PeerManager) readLoop(peer *Peer, conn *SecureConn, writer *connWriter) {
+	chunks := newAssembler(pm.maxMessageSize.Load())
+	for {
+		data, err := conn.ReadEncrypted()
 		if err != nil {
-			log.Printf("[INFO] Disconnected from peer %s: %v", peer.ID, err)
-
 			pm.mu.Lock()
-			peer.Conn.Close()
-			peer.Conn = nil
+			conn.Close()
+			delete(pm.conns, conn)
+			if writer != nil {
+				writer.close()
+			}
+			active := peer.Conn == conn
+			if active {
+				peer.Conn = nil
+				peer.writer = nil
+			}
 			pm.mu.Unlock()
 
-			// Optional: notify upper layer peer went offline
-			if pm.onPeerDisconnected != nil {
-				pm.onPeerDisconnected(peer.ID)
+			if pm.ctx.Err() != nil {
+				// Shutting down
+				return
+			}
+
+			var seqErr *SequenceError
+			switch {
+			case errors.Is(err, ErrTamperedFrame) || errors.As(err, &seqErr):
+				log.Printf("[SECURITY WARNING] Dropping session with %s: %v", peer.ID, err)
+			case !active:
+				// Replaced by another connection to the same peer
+				return
+			case isTimeout(err):
+				log.Printf("[INFO] Peer %s stopped responding, closing connection", peer.ID)
+			default:
+				log.Printf("[INFO] Disconnected from peer %s: %v", peer.ID, err)
+			}
+
+			if active {
+				pm.notifyConnState(peer.ID, ConnDisconnected)
+				pm.wakeSupervisor(peer.ID)
 			}
 			return
 		}
-		if pm.onMessage != nil {
-			var envelop models.Envelope
-			if err := proto.Unmarshal(data, &envelop); err == nil {
-				pm.onMessage(peer.ID, &envelop)
-			} else {
+		var envelop models.Envelope
+		if err := proto.Unmarshal(data, &envelop); err != nil {
+			fmt.Printf("[INVALID ENVELOP] from %s: %v\n", peer.ID, err)
+			continue
+		}
+
+		pm.mu.Lock()
+		joined := pm.markSeen(peer)
+		pm.mu.Unlock()
+		pm.emitPresence(joined)
+
+		// Large messages arrive as a series of chunks wrapping the real envelope
+		if chunk := envelop.GetChunk(); chunk != nil {
+			data, err := chunks.add(chunk)
+			if err != nil {
+				log.Printf("[WARN] Dropped chunked message from %s: %v", peer.ID, err)
+				continue
+			}
+			if data == nil {
+				continue
+			}
+			envelop.Reset()
+			if err := proto.Unmarshal(data, &envelop); err != nil || envelop.GetChunk() != nil {
 				fmt.Printf("[INVALID ENVELOP] from %s: %v\n", peer.ID, err)
+				continue
 			}
 		}
+
+		pm.dispatch(peer, &envelop)
+	}
+}
+
+// [dispatch] handles protocol envelopes internally and hands everything else to the application.
+func (pm *PeerManager) dispatch(peer *Peer, envelop *models.Envelope) {
+	switch payload := envelop.Payload.(type) {
+	case *models.Envelope_Subscriptions:
+		pm.mu.Lock()
+		peer.Topics = payload.Subscriptions.GetTopics()
+		pm.mu.Unlock()
+		return
+	case *models.Envelope_Receipt:
+		if pm.onReceipt != nil {
+			pm.onReceipt(peer.ID, payload.Receipt)
+		}
+		return
+	case *models.Envelope_Message:
+		pm.spawn(func() { pm.acknowledge(peer.ID, envelop) })
+		pm.record(history.Inbound, peer.ID, envelop)
+	default:
+		if pm.handleFileEnvelope(peer.ID, envelop) {
+			return
+		}
+	}
+
+	if pm.onReceive != nil {
+		pm.onReceive(peer.ID, envelop)
+	}
+	if msg := envelop.GetMessage(); msg != nil && pm.deliverTopic(peer.ID, msg) {
+		return
+	}
+	if pm.onMessage != nil {
+		pm.onMessage(peer.ID, envelop)
 	}
 }
@@ -0,0 +1,290 @@
+package comms
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/eglochon/simple-lan-messaging/models"
+	"github.com/eglochon/simple-lan-messaging/pkg/history"
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultOutboxTTL is how long a queued message waits for its peer unless overridden
+const DefaultOutboxTTL = 7 * 24 * time.Hour
+
+// outboxRetention is how long sent and expired entries stay visible in the status API
+const outboxRetention = 24 * time.Hour
+
+// OutboxStatus is the delivery state of a queued message
+type OutboxStatus string
+
+const (
+	OutboxQueued  OutboxStatus = "queued"
+	OutboxSent    OutboxStatus = "sent"
+	OutboxExpired OutboxStatus = "expired"
+)
+
+// OutboxEntry is a message waiting for, or delivered to, an offline peer
+type OutboxEntry struct {
+	ID       string       `json:"id"`
+	PeerID   string       `json:"peer"`
+	Envelope []byte       `json:"envelope"` // marshalled models.Envelope
+	Queued   time.Time    `json:"queued"`
+	Expires  time.Time    `json:"expires"`
+	Status   OutboxStatus `json:"status"`
+	SentAt   time.Time    `json:"sent_at,omitempty"`
+}
+
+// Outbox is a durable queue of messages for peers that are currently unreachable
+type Outbox struct {
+	path string
+
+	mu       sync.Mutex
+	entries  map[string]*OutboxEntry
+	flushing map[string]bool // peers with a flush in progress
+}
+
+// OpenOutbox loads the outbox at path, starting empty if the file doesn't exist
+func OpenOutbox(path string) (*Outbox, error) {
+	ob := &Outbox{
+		path:     path,
+		entries:  make(map[string]*OutboxEntry),
+		flushing: make(map[string]bool),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ob, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*OutboxEntry
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("invalid outbox %s: %w", path, err)
+	}
+	for _, entry := range list {
+		ob.entries[entry.ID] = entry
+	}
+	return ob, nil
+}
+
+// DefaultOutboxPath returns the outbox location in the working directory
+func DefaultOutboxPath() string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "outbox.json"
+	}
+	return filepath.Join(cwd, "outbox.json")
+}
+
+// Status returns the delivery state of a queued message
+func (ob *Outbox) Status(id string) (OutboxStatus, bool) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	ob.expire(time.Now())
+	entry, exists := ob.entries[id]
+	if !exists {
+		return "", false
+	}
+	return entry.Status, true
+}
+
+// Entries returns all entries, oldest first
+func (ob *Outbox) Entries() []OutboxEntry {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	ob.expire(time.Now())
+	list := make([]OutboxEntry, 0, len(ob.entries))
+	for _, entry := range ob.entries {
+		list = append(list, *entry)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Queued.Before(list[j].Queued) })
+	return list
+}
+
+// enqueue stores the message with envelope ID id for peerID, expiring after ttl
+func (ob *Outbox) enqueue(id, peerID string, data []byte, ttl time.Duration) (OutboxEntry, error) {
+	now := time.Now()
+	entry := &OutboxEntry{
+		ID:       id,
+		PeerID:   peerID,
+		Envelope: data,
+		Queued:   now,
+		Expires:  now.Add(ttl),
+		Status:   OutboxQueued,
+	}
+
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	ob.entries[entry.ID] = entry
+	return *entry, ob.save()
+}
+
+// pending returns the queued entries for peerID, oldest first
+func (ob *Outbox) pending(peerID string) []OutboxEntry {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	ob.expire(time.Now())
+	var list []OutboxEntry
+	for _, entry := range ob.entries {
+		if entry.PeerID == peerID && entry.Status == OutboxQueued {
+			list = append(list, *entry)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Queued.Before(list[j].Queued) })
+	return list
+}
+
+// hasPending reports whether anything is queued for peerID
+func (ob *Outbox) hasPending(peerID string) bool {
+	return len(ob.pending(peerID)) > 0
+}
+
+// markSent records a successful delivery
+func (ob *Outbox) markSent(id string) (OutboxEntry, error) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	entry, exists := ob.entries[id]
+	if !exists {
+		return OutboxEntry{}, fmt.Errorf("outbox entry not found: %s", id)
+	}
+	entry.Status = OutboxSent
+	entry.SentAt = time.Now()
+	return *entry, ob.save()
+}
+
+// expire marks overdue entries and drops finished ones past retention; callers must hold ob.mu
+func (ob *Outbox) expire(now time.Time) {
+	changed := false
+	for id, entry := range ob.entries {
+		switch {
+		case entry.Status == OutboxQueued && now.After(entry.Expires):
+			entry.Status = OutboxExpired
+			changed = true
+		case entry.Status == OutboxSent && now.After(entry.SentAt.Add(outboxRetention)),
+			entry.Status == OutboxExpired && now.After(entry.Expires.Add(outboxRetention)):
+			delete(ob.entries, id)
+			changed = true
+		}
+	}
+	if changed {
+		if err := ob.save(); err != nil {
+			log.Printf("[WARN] Failed to save outbox: %v", err)
+		}
+	}
+}
+
+// save atomically rewrites the outbox file; callers must hold ob.mu
+func (ob *Outbox) save() error {
+	list := make([]*OutboxEntry, 0, len(ob.entries))
+	for _, entry := range ob.entries {
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Queued.Before(list[j].Queued) })
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(ob.path), 0700); err != nil {
+		return err
+	}
+	tmp := ob.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ob.path)
+}
+
+// UseOutbox enables queueing of messages for unreachable peers.
+func (pm *PeerManager) UseOutbox(ob *Outbox) {
+	pm.outbox = ob
+}
+
+// OnOutboxSent registers a callback for queued messages that were finally delivered.
+func (pm *PeerManager) OnOutboxSent(fn func(entry OutboxEntry)) {
+	pm.onOutboxSent = fn
+}
+
+// SendOrQueue sends env right away, or queues it for up to ttl if the peer
+// is unknown or unreachable. It returns the envelope ID, which is also the
+// outbox entry ID when queued.
+func (pm *PeerManager) SendOrQueue(peerID string, env *models.Envelope, ttl time.Duration) (string, OutboxStatus, error) {
+	if err := stamp(env); err != nil {
+		return "", "", err
+	}
+	data, err := proto.Marshal(env)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = pm.sendMessage(peerID, data)
+	if err == nil {
+		pm.record(history.Outbound, peerID, env)
+		return env.Id, OutboxSent, nil
+	}
+	if pm.outbox == nil || !(errors.Is(err, ErrPeerNotFound) || errors.Is(err, ErrPeerUnreachable)) {
+		return "", "", err
+	}
+
+	if ttl <= 0 {
+		ttl = DefaultOutboxTTL
+	}
+	entry, err := pm.outbox.enqueue(env.Id, peerID, data, ttl)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to queue message: %w", err)
+	}
+	pm.record(history.Outbound, peerID, env)
+	return entry.ID, OutboxQueued, nil
+}
+
+// flushOutbox delivers queued messages to a peer that just became reachable.
+func (pm *PeerManager) flushOutbox(peerID string) {
+	ob := pm.outbox
+	if ob == nil {
+		return
+	}
+
+	ob.mu.Lock()
+	if ob.flushing[peerID] {
+		ob.mu.Unlock()
+		return
+	}
+	ob.flushing[peerID] = true
+	ob.mu.Unlock()
+
+	defer func() {
+		ob.mu.Lock()
+		delete(ob.flushing, peerID)
+		ob.mu.Unlock()
+	}()
+
+	for _, entry := range ob.pending(peerID) {
+		if err := pm.sendMessage(peerID, entry.Envelope); err != nil {
+			log.Printf("[OUTBOX] Delivery to %s failed, keeping %d message(s) queued: %v", peerID, len(ob.pending(peerID)), err)
+			return
+		}
+		sent, err := ob.markSent(entry.ID)
+		if err != nil {
+			log.Printf("[WARN] Failed to update outbox: %v", err)
+			continue
+		}
+		if pm.onOutboxSent != nil {
+			pm.onOutboxSent(sent)
+		}
+	}
+}
@@ -2,18 +2,28 @@ package comms
 
 import (
 	"fmt"
+	"slices"
+	"sync"
 	"time"
 )
 
 // Peer represents a known or connected remote client
 type Peer struct {
-	ID        string      // base64 Ed25519 public key
-	Name      string      // optional display name
-	IP        string      // last known IP address
-	Port      uint16      // service port for TCP connection
-	EncPubKey [32]byte    // X25519 public key
-	LastSeen  time.Time   // last discovery or message
-	Conn      *SecureConn // nil if not connected
+	ID        string        // base64 Ed25519 public key
+	Name      string        // optional display name
+	IP        string        // last known IP address
+	Port      uint16        // service port for TCP connection
+	EncPubKey [32]byte      // X25519 public key
+	LastSeen  time.Time     // last discovery or message
+	Presence  Presence      // online, idle or offline, see [PresencePolicy]
+	RTT       time.Duration // latest keepalive round-trip time, 0 if unknown
+	Conn      *SecureConn   // nil if not connected
+	Topics    []string      // topic patterns the peer subscribed to
+
+	announcedAt int64       // timestamp of the newest accepted discovery announcement
+	addrSeenAt  time.Time   // last announcement from IP
+	writer      *connWriter // serializes writes to Conn
+	dialMu      sync.Mutex  // one outgoing dial at a time
 }
 
 // Addr returns the peer's TCP address as "IP:Port"
@@ -25,3 +35,57 @@ func (p *Peer) Addr() string {
 func (p *Peer) IsConnected() bool {
 	return p.Conn != nil
 }
+
+// info copies the peer's state; the manager's lock must be held
+func (p *Peer) info() PeerInfo {
+	info := PeerInfo{
+		ID:        p.ID,
+		Name:      p.Name,
+		IP:        p.IP,
+		Port:      p.Port,
+		EncPubKey: p.EncPubKey,
+		LastSeen:  p.LastSeen,
+		Presence:  p.Presence,
+		RTT:       p.RTT,
+		Topics:    slices.Clone(p.Topics),
+	}
+	if p.Conn != nil {
+		info.Session = &SessionInfo{
+			Version:  p.Conn.Version(),
+			Features: p.Conn.Features(),
+			Stats:    p.Conn.Stats(),
+		}
+	}
+	return info
+}
+
+// PeerInfo is a snapshot of a peer, safe to read while the peer changes
+type PeerInfo struct {
+	ID        string
+	Name      string
+	IP        string
+	Port      uint16
+	EncPubKey [32]byte
+	LastSeen  time.Time
+	Presence  Presence
+	RTT       time.Duration
+	Topics    []string
+	Session   *SessionInfo // nil if not connected
+}
+
+// SessionInfo describes the live connection to a peer
+type SessionInfo struct {
+	Version  uint32   // negotiated protocol version
+	Features []string // features supported by both sides
+	Stats    ConnStats
+}
+
+// Addr returns the peer's TCP address as "IP:Port"
+func (p PeerInfo) Addr() string {
+	return fmt.Sprintf("%s:%d", p.IP, p.Port)
+}
+
+// IsConnected returns true if the peer had a live connection when the snapshot was taken
+func (p PeerInfo) IsConnected() bool {
+	return p.Session != nil
+}
@@ -0,0 +1,135 @@
+package comms
+
+import (
+	"log"
+	"time"
+)
+
+// Presence is how recently a peer was heard from
+type Presence string
+
+const (
+	PresenceOnline  Presence = "online"
+	PresenceIdle    Presence = "idle"
+	PresenceOffline Presence = "offline"
+)
+
+// PresencePolicy sets how long a peer may stay silent before it is
+// considered idle, offline, and finally forgotten. Connected peers stay online.
+type PresencePolicy struct {
+	IdleAfter    time.Duration
+	OfflineAfter time.Duration
+	EvictAfter   time.Duration // zero keeps offline peers forever
+}
+
+// DefaultPresencePolicy suits the default discovery interval of a few seconds
+var DefaultPresencePolicy = PresencePolicy{
+	IdleAfter:    10 * time.Second,
+	OfflineAfter: 30 * time.Second,
+	EvictAfter:   10 * time.Minute,
+}
+
+// presenceEvent is a state change reported once the lock is released
+type presenceEvent struct {
+	peerID string
+	joined bool
+}
+
+// OnPeerJoined registers a callback for peers that came online.
+func (pm *PeerManager) OnPeerJoined(fn func(peerID string)) {
+	pm.onPeerJoined = fn
+}
+
+// OnPeerLeft registers a callback for peers that went offline or were evicted.
+func (pm *PeerManager) OnPeerLeft(fn func(peerID string)) {
+	pm.onPeerLeft = fn
+}
+
+// TrackPresence periodically updates peer presence and evicts stale peers
+// until the manager is closed.
+func (pm *PeerManager) TrackPresence(policy PresencePolicy) {
+	pm.mu.Lock()
+	pm.presencePolicy = policy
+	pm.mu.Unlock()
+
+	interval := max(min(policy.IdleAfter, policy.OfflineAfter)/4, 100*time.Millisecond)
+	pm.spawn(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-pm.ctx.Done():
+				return
+			case now := <-ticker.C:
+				pm.sweepPresence(now)
+			}
+		}
+	})
+}
+
+// markSeen records activity from a peer; callers must hold pm.mu. It
+// returns a joined event if the peer was offline.
+func (pm *PeerManager) markSeen(peer *Peer) *presenceEvent {
+	peer.LastSeen = time.Now()
+	if peer.Presence == PresenceOnline {
+		return nil
+	}
+	wasOffline := peer.Presence != PresenceIdle
+	peer.Presence = PresenceOnline
+	if !wasOffline || peer.ID == pm.self.GetID() {
+		return nil
+	}
+	return &presenceEvent{peerID: peer.ID, joined: true}
+}
+
+// sweepPresence moves silent peers to idle or offline and evicts stale ones
+func (pm *PeerManager) sweepPresence(now time.Time) {
+	var events []*presenceEvent
+
+	pm.mu.Lock()
+	policy := pm.presencePolicy
+	for id, peer := range pm.peers {
+		if peer.Conn != nil || id == pm.self.GetID() {
+			continue
+		}
+
+		silent := now.Sub(peer.LastSeen)
+		state := PresenceOnline
+		switch {
+		case silent >= policy.OfflineAfter:
+			state = PresenceOffline
+		case silent >= policy.IdleAfter:
+			state = PresenceIdle
+		}
+
+		if state == PresenceOffline && peer.Presence != PresenceOffline {
+			events = append(events, &presenceEvent{peerID: id})
+		}
+		peer.Presence = state
+
+		if policy.EvictAfter > 0 && silent >= policy.EvictAfter {
+			log.Printf("[INFO] Forgetting peer %s, not seen since %s", id, peer.LastSeen.Format(time.DateTime))
+			delete(pm.peers, id)
+		}
+	}
+	pm.mu.Unlock()
+
+	pm.emitPresence(events...)
+}
+
+// emitPresence calls the joined and left callbacks; callers must not hold pm.mu
+func (pm *PeerManager) emitPresence(events ...*presenceEvent) {
+	for _, event := range events {
+		if event == nil {
+			continue
+		}
+		// A supervisor pauses while its peer is offline
+		pm.wakeSupervisor(event.peerID)
+		switch {
+		case event.joined && pm.onPeerJoined != nil:
+			pm.onPeerJoined(event.peerID)
+		case !event.joined && pm.onPeerLeft != nil:
+			pm.onPeerLeft(event.peerID)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package comms
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/eglochon/simple-lan-messaging/models"
+	"github.com/eglochon/simple-lan-messaging/pkg/history"
+)
+
+// TopicHandler is called for every received message whose topic matches a subscription
+type TopicHandler func(peerID string, msg *models.TopicMessage)
+
+// MatchTopic reports whether topic matches pattern. Patterns use path.Match
+// syntax, so "team/*" matches "team/dev" but not "team/dev/ops".
+func MatchTopic(pattern, topic string) bool {
+	ok, err := path.Match(pattern, topic)
+	return err == nil && ok
+}
+
+// Subscribe registers a handler for all topics matching pattern and tells
+// connected peers about it. Other peers learn it from our next announcement.
+// A nil handler only asks peers for the topic, its messages go to OnMessage.
+func (pm *PeerManager) Subscribe(pattern string, handler TopicHandler) error {
+	if pattern == "" {
+		return errors.New("empty topic pattern")
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid topic pattern %q: %w", pattern, err)
+	}
+
+	pm.subMu.Lock()
+	_, existed := pm.subs[pattern]
+	pm.subs[pattern] = append(pm.subs[pattern], handler)
+	pm.subMu.Unlock()
+
+	if !existed {
+		pm.spawn(pm.announceSubscriptions)
+	}
+	return nil
+}
+
+// Unsubscribe removes every handler registered for pattern and tells connected peers about it.
+func (pm *PeerManager) Unsubscribe(pattern string) {
+	pm.subMu.Lock()
+	_, existed := pm.subs[pattern]
+	delete(pm.subs, pattern)
+	pm.subMu.Unlock()
+
+	if existed {
+		pm.spawn(pm.announceSubscriptions)
+	}
+}
+
+// Subscriptions returns the sorted list of local topic patterns
+func (pm *PeerManager) Subscriptions() []string {
+	pm.subMu.RLock()
+	defer pm.subMu.RUnlock()
+
+	topics := make([]string, 0, len(pm.subs))
+	for pattern := range pm.subs {
+		topics = append(topics, pattern)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// Publish sends content on topic to every peer that subscribed to a matching
+// pattern and returns the message ID, which is the same for all recipients.
+func (pm *PeerManager) Publish(topic, content string) (string, error) {
+	if topic == "" || strings.ContainsAny(topic, `*?[\`) {
+		return "", fmt.Errorf("invalid topic %q", topic)
+	}
+
+	env := &models.Envelope{
+		Type: "message",
+		Payload: &models.Envelope_Message{
+			Message: &models.TopicMessage{Topic: topic, Content: content},
+		},
+	}
+	if err := stamp(env); err != nil {
+		return "", err
+	}
+	data, err := marshalProto(env)
+	if err != nil {
+		return "", err
+	}
+
+	// Recorded once for the topic, unless nobody could be reached
+	subscribers := pm.subscribers(topic)
+	var errs []error
+	for _, peer := range subscribers {
+		if err := pm.sendMessage(peer.ID, data); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", peer.ID, err))
+		}
+	}
+	if len(subscribers) == 0 || len(errs) < len(subscribers) {
+		pm.record(history.Outbound, "", env)
+	}
+	return env.Id, errors.Join(errs...)
+}
+
+// subscribers returns all peers that announced a pattern matching topic
+func (pm *PeerManager) subscribers(topic string) []*Peer {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	var list []*Peer
+	for _, peer := range pm.peers {
+		for _, pattern := range peer.Topics {
+			if MatchTopic(pattern, topic) {
+				list = append(list, peer)
+				break
+			}
+		}
+	}
+	return list
+}
+
+// deliverTopic passes msg to matching local handlers and reports whether any handled it
+func (pm *PeerManager) deliverTopic(peerID string, msg *models.TopicMessage) bool {
+	if msg.GetTopic() == "" {
+		return false
+	}
+
+	pm.subMu.RLock()
+	var handlers []TopicHandler
+	for pattern, hs := range pm.subs {
+		if !MatchTopic(pattern, msg.GetTopic()) {
+			continue
+		}
+		for _, handler := range hs {
+			if handler != nil {
+				handlers = append(handlers, handler)
+			}
+		}
+	}
+	pm.subMu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(peerID, msg)
+	}
+	return len(handlers) > 0
+}
+
+// announceSubscriptions pushes our subscriptions to every connected peer.
+// Nobody is dialed for it, peers without a connection read them from our
+// discovery announcements.
+func (pm *PeerManager) announceSubscriptions() {
+	pm.mu.RLock()
+	var peers []*Peer
+	for _, peer := range pm.peers {
+		if peer.Conn != nil {
+			peers = append(peers, peer)
+		}
+	}
+	pm.mu.RUnlock()
+
+	for _, peer := range peers {
+		if err := pm.sendSubscriptions(peer); err != nil {
+			log.Printf("[WARN] Failed to announce subscriptions to %s: %v", peer.ID, err)
+		}
+	}
+}
+
+// sendSubscriptions writes our current subscription list to the peer's
+// connection. Peers without pubsub are left alone.
+func (pm *PeerManager) sendSubscriptions(peer *Peer) error {
+	pm.mu.RLock()
+	conn := peer.Conn
+	pm.mu.RUnlock()
+	if conn == nil || !conn.HasFeature(FeaturePubSub) {
+		return nil
+	}
+
+	data, err := marshalProto(&models.Envelope{
+		Type: "subscriptions",
+		Payload: &models.Envelope_Subscriptions{
+			Subscriptions: &models.Subscriptions{Topics: pm.Subscriptions()},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return pm.writeTo(peer, data)
+}
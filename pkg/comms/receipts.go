@@ -0,0 +1,59 @@
+package comms
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/eglochon/simple-lan-messaging/models"
+)
+
+// OnReceipt registers a callback for delivery and read receipts from peers.
+func (pm *PeerManager) OnReceipt(fn func(peerID string, receipt *models.Receipt)) {
+	pm.onReceipt = fn
+}
+
+// MarkRead tells a peer that the messages with the given IDs were read.
+func (pm *PeerManager) MarkRead(peerID string, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return pm.sendReceipt(peerID, models.ReceiptStatus_READ, ids)
+}
+
+func (pm *PeerManager) sendReceipt(peerID string, status models.ReceiptStatus, ids []string) error {
+	return pm.sendFeature(peerID, FeatureReceipts, &models.Envelope{
+		Type: "receipt",
+		Payload: &models.Envelope_Receipt{
+			Receipt: &models.Receipt{Ids: ids, Status: status},
+		},
+	})
+}
+
+// acknowledge sends a delivery receipt for a received message
+func (pm *PeerManager) acknowledge(peerID string, env *models.Envelope) {
+	if env.GetId() == "" {
+		return
+	}
+	err := pm.sendReceipt(peerID, models.ReceiptStatus_DELIVERED, []string{env.GetId()})
+	if err != nil && !errors.Is(err, ErrFeatureUnsupported) {
+		log.Printf("[WARN] Failed to acknowledge message %s from %s: %v", env.GetId(), peerID, err)
+	}
+}
+
+// stamp gives env a unique ID and the current time unless it already has them
+func stamp(env *models.Envelope) error {
+	if env.Id == "" {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return err
+		}
+		env.Id = hex.EncodeToString(id)
+	}
+	if env.Timestamp == 0 {
+		env.Timestamp = time.Now().UnixMilli()
+	}
+	return nil
+}
@@ -1,9 +1,12 @@
 package comms
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/eglochon/simple-lan-messaging/pkg/identity"
@@ -11,57 +14,104 @@ import (
 
 // TCPReceiver listens for incoming peer connections and passes them to the PeerManager
 type TCPReceiver struct {
-	addr    string
-	pm      *PeerManager
-	self    *identity.Identity
-	ln      net.Listener
-	running bool
+	addr string
+	pm   *PeerManager
+	self *identity.Identity
+	ln   net.Listener
+
+	mu      sync.Mutex
+	closed  bool
+	pending map[net.Conn]struct{} // connections still in the handshake
+	wg      sync.WaitGroup
 }
 
 // NewTCPReceiver creates a new TCP server bound to the given address (e.g. ":9000")
 func NewTCPReceiver(addr string, self *identity.Identity, pm *PeerManager) *TCPReceiver {
 	return &TCPReceiver{
-		addr: addr,
-		self: self,
-		pm:   pm,
+		addr:    addr,
+		self:    self,
+		pm:      pm,
+		pending: make(map[net.Conn]struct{}),
 	}
 }
 
-// Start begins accepting incoming TCP connections and registering them
-func (r *TCPReceiver) Start() error {
+// Start begins accepting incoming TCP connections and registering them.
+// Cancelling ctx closes the listener and aborts pending handshakes.
+func (r *TCPReceiver) Start(ctx context.Context) error {
 	var err error
 	r.ln, err = net.Listen("tcp", r.addr)
 	if err != nil {
 		return fmt.Errorf("listen failed: %w", err)
 	}
 
-	r.running = true
 	log.Printf("[RECEIVER] Listening on %s", r.addr)
 
-	go func() {
-		for r.running {
-			conn, err := r.ln.Accept()
-			if err != nil {
-				if r.running {
-					log.Printf("[RECEIVER] Accept error: %v", err)
-				}
-				continue
-			}
-
-			go r.handleConnection(conn)
-		}
-	}()
+	r.wg.Add(1)
+	go r.accept()
+	context.AfterFunc(ctx, func() { r.shutdown() })
 
 	return nil
 }
 
-// Stop gracefully shuts down the listener
-func (r *TCPReceiver) Stop() error {
-	r.running = false
-	if r.ln != nil {
-		return r.ln.Close()
+// Close stops accepting connections and waits for pending handshakes to end.
+// Established connections belong to the PeerManager and stay open.
+func (r *TCPReceiver) Close() error {
+	err := r.shutdown()
+	r.wg.Wait()
+	return err
+}
+
+// shutdown closes the listener and every connection still in the handshake
+func (r *TCPReceiver) shutdown() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed || r.ln == nil {
+		return nil
+	}
+	r.closed = true
+
+	errs := []error{r.ln.Close()}
+	for conn := range r.pending {
+		if err := conn.Close(); !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *TCPReceiver) accept() {
+	defer r.wg.Done()
+	for {
+		conn, err := r.ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Printf("[RECEIVER] Accept error: %v", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		r.mu.Lock()
+		if r.closed {
+			r.mu.Unlock()
+			conn.Close()
+			return
+		}
+		r.pending[conn] = struct{}{}
+		r.wg.Add(1)
+		r.mu.Unlock()
+
+		go func() {
+			defer r.wg.Done()
+			r.handleConnection(conn)
+
+			r.mu.Lock()
+			delete(r.pending, conn)
+			r.mu.Unlock()
+		}()
 	}
-	return nil
 }
 
 // handleConnection performs handshake and registers peer
@@ -82,17 +132,13 @@ func (r *TCPReceiver) handleConnection(conn net.Conn) {
 	}
 	conn.SetDeadline(time.Time{}) // remove timeout
 
-	r.pm.mu.Lock()
-	peer, exists := r.pm.peers[peerID]
-	if !exists {
-		peer = &Peer{ID: peerID}
-		r.pm.peers[peerID] = peer
+	if r.pm.isBlocked(peerID) {
+		log.Printf("[RECEIVER] Refused connection from blocked peer %s", peerID)
+		_ = sc.Close()
+		return
 	}
-	peer.Conn = sc
-	peer.LastSeen = time.Now()
-	r.pm.mu.Unlock()
 
-	log.Printf("[RECEIVER] Secure connection established with %s", peerID)
+	log.Printf("[RECEIVER] Secure connection established with %s (protocol v%d, features %v)", peerID, sc.Version(), sc.Features())
 
-	go r.pm.readLoop(peer)
+	r.pm.attach(r.pm.getOrAddPeer(peerID), sc)
 }
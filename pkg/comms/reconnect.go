@@ -0,0 +1,173 @@
+package comms
+
+import (
+	"log"
+	"math/rand/v2"
+	"time"
+)
+
+// ConnState is reported to [PeerManager.OnConnState] callbacks
+type ConnState string
+
+const (
+	ConnConnected    ConnState = "connected"
+	ConnDisconnected ConnState = "disconnected"
+	ConnReconnecting ConnState = "reconnecting" // a supervisor is redialing
+	ConnPaused       ConnState = "paused"       // a supervisor waits for the peer to be discovered again
+)
+
+// ReconnectPolicy decides how supervised peers are redialed. Delays double
+// after each failed attempt, from MinDelay up to MaxDelay.
+type ReconnectPolicy struct {
+	MinDelay    time.Duration
+	MaxDelay    time.Duration
+	DialTimeout time.Duration // covers connecting and the handshake, 0 waits forever
+}
+
+// DefaultReconnectPolicy retries quickly at first and settles at a couple of minutes
+var DefaultReconnectPolicy = ReconnectPolicy{
+	MinDelay:    time.Second,
+	MaxDelay:    2 * time.Minute,
+	DialTimeout: 5 * time.Second,
+}
+
+// backoff returns the delay before the given retry, randomized between half
+// and all of it so peers that lost each other at once don't redial in lockstep
+func (p ReconnectPolicy) backoff(attempt int) time.Duration {
+	delay := p.MinDelay
+	for i := 0; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, p.MaxDelay)
+	half := delay / 2
+	return half + rand.N(delay-half+1)
+}
+
+// supervisor keeps one peer connected
+type supervisor struct {
+	wake chan struct{}
+	stop chan struct{}
+}
+
+// minReconnectDelay keeps a supervisor from redialing an unreachable peer in
+// a tight loop
+const minReconnectDelay = 100 * time.Millisecond
+
+// SetReconnectPolicy changes how peers are redialed and how long dials may
+// take. MinDelay is raised to 100ms if shorter, and MaxDelay to MinDelay.
+func (pm *PeerManager) SetReconnectPolicy(policy ReconnectPolicy) {
+	policy.MinDelay = max(policy.MinDelay, minReconnectDelay)
+	policy.MaxDelay = max(policy.MaxDelay, policy.MinDelay)
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.reconnectPolicy = policy
+}
+
+// OnConnState registers a callback for connection state changes.
+func (pm *PeerManager) OnConnState(fn func(peerID string, state ConnState)) {
+	pm.onConnState = fn
+}
+
+// Supervise keeps a connection to peerID open, redialing whenever it drops.
+// Redialing pauses while the peer is offline or unknown.
+func (pm *PeerManager) Supervise(peerID string) {
+	if peerID == pm.self.GetID() {
+		return
+	}
+	pm.supMu.Lock()
+	defer pm.supMu.Unlock()
+
+	if _, exists := pm.supervisors[peerID]; exists {
+		return
+	}
+	s := &supervisor{
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+	}
+	pm.supervisors[peerID] = s
+	pm.spawn(func() { pm.supervise(peerID, s) })
+}
+
+// Unsupervise stops redialing peerID; an open connection stays open.
+func (pm *PeerManager) Unsupervise(peerID string) {
+	pm.supMu.Lock()
+	defer pm.supMu.Unlock()
+
+	if s, exists := pm.supervisors[peerID]; exists {
+		close(s.stop)
+		delete(pm.supervisors, peerID)
+	}
+}
+
+// wakeSupervisor makes the supervisor of peerID look at the peer again
+func (pm *PeerManager) wakeSupervisor(peerID string) {
+	pm.supMu.Lock()
+	s := pm.supervisors[peerID]
+	pm.supMu.Unlock()
+
+	if s == nil {
+		return
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (pm *PeerManager) notifyConnState(peerID string, state ConnState) {
+	if pm.onConnState != nil {
+		pm.onConnState(peerID, state)
+	}
+}
+
+// supervise redials peerID until Unsupervise. It sleeps while the peer is
+// connected or absent and is woken by connection and presence changes.
+func (pm *PeerManager) supervise(peerID string, s *supervisor) {
+	var state ConnState
+	report := func(next ConnState) {
+		if next != state {
+			state = next
+			pm.notifyConnState(peerID, next)
+		}
+	}
+
+	attempt := 0
+	for {
+		pm.mu.RLock()
+		peer, exists := pm.peers[peerID]
+		connected := exists && peer.Conn != nil
+		present := exists && peer.Presence != PresenceOffline
+		policy := pm.reconnectPolicy
+		pm.mu.RUnlock()
+
+		var retry <-chan time.Time
+		switch {
+		case connected:
+			state = ConnConnected
+			attempt = 0
+		case !present || pm.isBlocked(peerID):
+			report(ConnPaused)
+			attempt = 0
+		default:
+			report(ConnReconnecting)
+			_, err := pm.Connect(peerID)
+			if err == nil {
+				continue
+			}
+			delay := policy.backoff(attempt)
+			attempt++
+			log.Printf("[INFO] Reconnecting to %s in %s: %v", peerID, delay.Round(time.Millisecond), err)
+			retry = time.After(delay)
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-pm.ctx.Done():
+			return
+		case <-s.wake:
+		case <-retry:
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package comms
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetReconnectPolicyBoundsDelays(t *testing.T) {
+	for _, tc := range []struct {
+		policy   ReconnectPolicy
+		min, max time.Duration
+	}{
+		{ReconnectPolicy{}, minReconnectDelay, minReconnectDelay},
+		{ReconnectPolicy{MinDelay: -time.Second, MaxDelay: time.Minute}, minReconnectDelay, time.Minute},
+		{ReconnectPolicy{MinDelay: 10 * time.Second, MaxDelay: time.Second}, 10 * time.Second, 10 * time.Second},
+		{DefaultReconnectPolicy, DefaultReconnectPolicy.MinDelay, DefaultReconnectPolicy.MaxDelay},
+	} {
+		pm := NewPeerManager(newTestIdentity(t))
+		pm.SetReconnectPolicy(tc.policy)
+		got := pm.reconnectPolicy
+		if got.MinDelay != tc.min || got.MaxDelay != tc.max {
+			t.Errorf("%+v: got delays %s to %s, want %s to %s", tc.policy, got.MinDelay, got.MaxDelay, tc.min, tc.max)
+		}
+		for attempt := range 5 {
+			if delay := got.backoff(attempt); delay < tc.min/2 || delay > tc.max {
+				t.Errorf("%+v: retry %d after %s, want between %s and %s", tc.policy, attempt, delay, tc.min/2, tc.max)
+			}
+		}
+		pm.Close()
+	}
+}
@@ -0,0 +1,121 @@
+package comms
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// RekeyPolicy decides when a connection switches to a fresh send key. A zero
+// limit disables that trigger.
+type RekeyPolicy struct {
+	Messages uint64        // frames sent under one key
+	Bytes    uint64        // plaintext bytes sent under one key
+	Interval time.Duration // age of the key
+}
+
+// DefaultRekeyPolicy keeps every key well below the AES-GCM usage limits
+var DefaultRekeyPolicy = RekeyPolicy{
+	Messages: 1 << 20,
+	Bytes:    1 << 30,
+	Interval: time.Hour,
+}
+
+// keyUsage counts what was sent under the current send key
+type keyUsage struct {
+	frames uint64
+	bytes  uint64
+	since  time.Time
+}
+
+func (p RekeyPolicy) due(u keyUsage) bool {
+	return (p.Messages > 0 && u.frames >= p.Messages) ||
+		(p.Bytes > 0 && u.bytes >= p.Bytes) ||
+		(p.Interval > 0 && time.Since(u.since) >= p.Interval)
+}
+
+// ConnStats reports how often each side of a connection changed keys
+type ConnStats struct {
+	RekeysSent     uint64
+	RekeysReceived uint64
+}
+
+// SetRekeyPolicy changes when connections attached from now on rekey.
+func (pm *PeerManager) SetRekeyPolicy(policy RekeyPolicy) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.rekeyPolicy = policy
+}
+
+// SetRekeyPolicy changes when this connection rekeys its sending direction.
+func (sc *SecureConn) SetRekeyPolicy(policy RekeyPolicy) {
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+	sc.policy = policy
+}
+
+// Stats returns the rekey counters of this connection.
+func (sc *SecureConn) Stats() ConnStats {
+	return ConnStats{
+		RekeysSent:     sc.rekeysSent.Load(),
+		RekeysReceived: sc.rekeysReceived.Load(),
+	}
+}
+
+// rekeySend announces a fresh salt under the current key, then switches to
+// the key derived from it; callers must hold sc.writeMu
+func (sc *SecureConn) rekeySend() error {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	if err := sc.writeFrame(frameRekey, salt); err != nil {
+		return err
+	}
+
+	key, err := nextKey(sc.sendKey, salt)
+	if err != nil {
+		return err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	sc.send, sc.sendKey = aead, key
+	sc.keyUsage = keyUsage{since: time.Now()}
+	sc.rekeysSent.Add(1)
+	return nil
+}
+
+// rekeyRecv follows the peer to its next send key
+func (sc *SecureConn) rekeyRecv(salt []byte) error {
+	if len(salt) != 32 {
+		return fmt.Errorf("invalid rekey salt of %d bytes", len(salt))
+	}
+	key, err := nextKey(sc.recvKey, salt)
+	if err != nil {
+		return err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	sc.recv, sc.recvKey = aead, key
+	sc.rekeysReceived.Add(1)
+	return nil
+}
+
+// nextKey derives the key that replaces current; the old key can't be
+// recovered from the new one
+func nextKey(current, salt []byte) ([]byte, error) {
+	key := make([]byte, 32)
+	r := hkdf.New(sha256.New, current, salt, []byte(handshakeLabel+" rekey"))
+	if _, err := io.ReadFull(r, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
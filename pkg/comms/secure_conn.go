@@ -1,15 +1,22 @@
 package comms
 
 import (
-	"crypto/aes"
+	"bytes"
+	"context"
 	"crypto/cipher"
 	"crypto/ed25519"
 	"crypto/rand"
-	"crypto/sha256"
 	"encoding/base64"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"math"
 	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/eglochon/simple-lan-messaging/models"
 	"github.com/eglochon/simple-lan-messaging/pkg/identity"
@@ -17,80 +24,272 @@ import (
 	"google.golang.org/protobuf/proto"
 )
 
+// MaxFrameSize is the largest plaintext that fits in one encrypted frame
+// (2-byte length prefix minus the rest of the header and the GCM tag)
+const MaxFrameSize = 0xFFFF - headerSize - 16
+
+// Each frame header holds an 8-byte sequence number and a 1-byte frame kind
+const (
+	seqSize    = 8
+	headerSize = seqSize + 1
+)
+
+// Frame kinds
+const (
+	frameData  byte = 0 // application payload
+	frameRekey byte = 1 // carries the salt for the sender's next key
+	framePing  byte = 2 // asks the peer to echo the payload in a pong
+	framePong  byte = 3 // echoes the payload of a ping
+)
+
+var (
+	ErrNonceExhausted = errors.New("nonce counter exhausted, reconnect required")
+	ErrTamperedFrame  = errors.New("frame authentication failed")
+)
+
+// SequenceError reports a frame that authenticated but arrived with an
+// unexpected sequence number: a replay when it was already seen, a gap when
+// frames were dropped or reordered. Either way the session can't be trusted.
+type SequenceError struct {
+	Expected uint64
+	Got      uint64
+}
+
+func (e *SequenceError) Error() string {
+	if e.Replay() {
+		return fmt.Sprintf("replayed frame %d, expected %d", e.Got, e.Expected)
+	}
+	return fmt.Sprintf("frame sequence gap: got %d, expected %d", e.Got, e.Expected)
+}
+
+// Replay reports whether the frame repeats an already received sequence number
+func (e *SequenceError) Replay() bool {
+	return e.Got < e.Expected
+}
+
 type SecureConn struct {
-	conn   net.Conn
-	stream cipher.AEAD
+	conn      net.Conn
+	initiator bool     // true if we dialed this connection
+	version   uint32   // negotiated protocol version
+	features  []string // features supported by both sides
+
+	writeMu  sync.Mutex
+	send     cipher.AEAD // cipher for our direction
+	sendKey  []byte
+	sendSeq  uint64 // sequence number of the next frame we write
+	policy   RekeyPolicy
+	keyUsage keyUsage // traffic under the current send key
+
+	recv    cipher.AEAD // cipher for the peer's direction
+	recvKey []byte
+	recvSeq uint64 // sequence number of the next frame we expect
+
+	rekeysSent     atomic.Uint64
+	rekeysReceived atomic.Uint64
+
+	pingID            uint64 // last ping sent, guarded by writeMu
+	pingSent          atomic.Pointer[pendingPing]
+	rtt               atomic.Int64           // latest round-trip time in nanoseconds
+	keepaliveDeadline atomic.Int64           // read deadline after each frame, 0 if unset
+	pong              atomic.Pointer[[]byte] // payload of the latest unanswered ping
+	pongReady         chan struct{}          // signals the writer that pong is set
+
+	retired   atomic.Bool
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// Version returns the protocol version negotiated for this connection
+func (sc *SecureConn) Version() uint32 {
+	return sc.version
+}
+
+// Features returns the optional features both sides support
+func (sc *SecureConn) Features() []string {
+	return slices.Clone(sc.features)
+}
+
+// HasFeature reports whether both sides support feature
+func (sc *SecureConn) HasFeature(feature string) bool {
+	return slices.Contains(sc.features, feature)
 }
 
 func (sc *SecureConn) WriteEncrypted(plaintext []byte) error {
-	nonce := make([]byte, sc.stream.NonceSize())
-	if _, err := rand.Read(nonce); err != nil {
-		return err
+	if len(plaintext) > MaxFrameSize {
+		return fmt.Errorf("frame of %d bytes exceeds %d", len(plaintext), MaxFrameSize)
 	}
-	ciphertext := sc.stream.Seal(nonce, nonce, plaintext, nil)
 
-	lenBuf := make([]byte, 2)
-	lenBuf[0], lenBuf[1] = byte(len(ciphertext)>>8), byte(len(ciphertext))
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
 
-	if _, err := sc.conn.Write(lenBuf); err != nil {
+	if sc.policy.due(sc.keyUsage) {
+		if err := sc.rekeySend(); err != nil {
+			return err
+		}
+	}
+	if err := sc.writeFrame(frameData, plaintext); err != nil {
 		return err
 	}
-	_, err := sc.conn.Write(ciphertext)
+	sc.keyUsage.frames++
+	sc.keyUsage.bytes += uint64(len(plaintext))
+	return nil
+}
+
+// writeFrame seals and writes one frame; callers must hold sc.writeMu
+func (sc *SecureConn) writeFrame(kind byte, plaintext []byte) error {
+	if sc.sendSeq == math.MaxUint64 {
+		return ErrNonceExhausted
+	}
+	if sc.retired.Load() {
+		return errWriterClosed
+	}
+
+	// Header: 2-byte length of the rest, then the sequence number and frame
+	// kind, which are authenticated as additional data. The sequence number
+	// doubles as the nonce. The frame goes out in a single write.
+	frame := make([]byte, 2+headerSize, 2+headerSize+len(plaintext)+sc.send.Overhead())
+	binary.BigEndian.PutUint16(frame, uint16(cap(frame)-2))
+	binary.BigEndian.PutUint64(frame[2:], sc.sendSeq)
+	frame[2+seqSize] = kind
+	frame = sc.send.Seal(frame, counterNonce(sc.sendSeq), plaintext, frame[2:2+headerSize])
+	sc.sendSeq++
+
+	_, err := sc.conn.Write(frame)
 	return err
 }
 
+// ReadEncrypted returns the next data frame, handling rekey frames on the
+// way; it must not be called concurrently. Forged frames fail with
+// ErrTamperedFrame, replayed or missing ones with a *SequenceError.
 func (sc *SecureConn) ReadEncrypted() ([]byte, error) {
+	for {
+		kind, plaintext, err := sc.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case frameData:
+			return plaintext, nil
+		case frameRekey:
+			if err := sc.rekeyRecv(plaintext); err != nil {
+				return nil, err
+			}
+		case framePing:
+			sc.handlePing(plaintext)
+		case framePong:
+			if err := sc.handlePong(plaintext); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unknown frame kind %d", kind)
+		}
+	}
+}
+
+func (sc *SecureConn) readFrame() (byte, []byte, error) {
 	lenBuf := make([]byte, 2)
 	if _, err := io.ReadFull(sc.conn, lenBuf); err != nil {
-		return nil, err
+		return 0, nil, err
 	}
-	length := int(lenBuf[0])<<8 | int(lenBuf[1])
-	if length > 64*1024 {
-		return nil, errors.New("message too large")
+	length := int(binary.BigEndian.Uint16(lenBuf))
+	if length < headerSize {
+		return 0, nil, ErrTamperedFrame
 	}
 
 	buf := make([]byte, length)
 	if _, err := io.ReadFull(sc.conn, buf); err != nil {
-		return nil, err
+		return 0, nil, err
 	}
 
-	nonceSize := sc.stream.NonceSize()
-	if len(buf) < nonceSize {
-		return nil, errors.New("invalid ciphertext")
+	seq := binary.BigEndian.Uint64(buf[:seqSize])
+	plaintext, err := sc.recv.Open(nil, counterNonce(seq), buf[headerSize:], buf[:headerSize])
+	if err != nil {
+		return 0, nil, ErrTamperedFrame
 	}
-	nonce := buf[:nonceSize]
-	ciphertext := buf[nonceSize:]
+	if seq != sc.recvSeq {
+		return 0, nil, &SequenceError{Expected: sc.recvSeq, Got: seq}
+	}
+	if sc.recvSeq == math.MaxUint64 {
+		return 0, nil, ErrNonceExhausted
+	}
+	sc.recvSeq++
+	sc.extendDeadline()
+	return buf[seqSize], plaintext, nil
+}
+
+// retireTimeout bounds how long a retired connection is kept open for reading
+const retireTimeout = 10 * time.Second
 
-	return sc.stream.Open(nil, nonce, ciphertext, nil)
+// retire stops writing on a connection that lost to another one to the same
+// peer. Reading goes on so frames the peer sent before it noticed are still
+// delivered, until the peer closes its end or retireTimeout passes.
+func (sc *SecureConn) retire() {
+	sc.retired.Store(true)
+	tcp, ok := sc.conn.(*net.TCPConn)
+	if !ok {
+		sc.conn.Close()
+		return
+	}
+	tcp.CloseWrite()
+	tcp.SetReadDeadline(time.Now().Add(retireTimeout))
 }
 
 func (sc *SecureConn) Close() error {
+	sc.closeOnce.Do(func() { close(sc.closed) })
 	return sc.conn.Close()
 }
 
 func DialSecurePeer(addr string, self *identity.Identity) (*SecureConn, string, error) {
-	conn, err := net.Dial("tcp", addr)
+	return DialSecurePeerTimeout(addr, self, 0)
+}
+
+// DialSecurePeerTimeout is like DialSecurePeer but gives up when connecting
+// and the handshake together take longer than timeout. Zero means no timeout.
+func DialSecurePeerTimeout(addr string, self *identity.Identity, timeout time.Duration) (*SecureConn, string, error) {
+	return DialSecurePeerContext(context.Background(), addr, self, timeout)
+}
+
+// DialSecurePeerContext is like DialSecurePeerTimeout but also gives up as
+// soon as ctx is done, even in the middle of the handshake.
+func DialSecurePeerContext(ctx context.Context, addr string, self *identity.Identity, timeout time.Duration) (*SecureConn, string, error) {
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
 	if err != nil {
 		return nil, "", err
 	}
-	aead, peerID, err := performHandshake(conn, self, true)
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	// Closing the connection unblocks the handshake
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	sc, peerID, err := performHandshake(conn, self, true)
+	if !stop() {
+		conn.Close()
+		return nil, "", ctx.Err()
+	}
 	if err != nil {
 		conn.Close()
 		return nil, "", err
 	}
-	return &SecureConn{conn: conn, stream: aead}, peerID, nil
+	conn.SetDeadline(time.Time{})
+	return sc, peerID, nil
 }
 
 func AcceptSecureConn(conn net.Conn, self *identity.Identity) (*SecureConn, string, error) {
-	aead, peerID, err := performHandshake(conn, self, false)
+	sc, peerID, err := performHandshake(conn, self, false)
 	if err != nil {
 		conn.Close()
 		return nil, "", err
 	}
-	return &SecureConn{conn: conn, stream: aead}, peerID, nil
+	return sc, peerID, nil
 }
 
-func performHandshake(conn net.Conn, self *identity.Identity, isInitiator bool) (cipher.AEAD, string, error) {
+// performHandshake runs the authenticated key exchange: both sides send a
+// Handshake with a fresh ephemeral key, then sign the transcript of both
+// messages in a HandshakeAuth. Session keys are derived from the shared
+// secret and the transcript, one per direction.
+func performHandshake(conn net.Conn, self *identity.Identity, isInitiator bool) (*SecureConn, string, error) {
 	var ephPriv [32]byte
 	if _, err := rand.Read(ephPriv[:]); err != nil {
 		return nil, "", err
@@ -103,77 +302,124 @@ func performHandshake(conn net.Conn, self *identity.Identity, isInitiator bool)
 		return nil, "", err
 	}
 
-	msgToSign := append(self.SigningPublicKey, ephPub[:]...)
-	msgToSign = append(msgToSign, nonce...)
-	signature := ed25519.Sign(self.SigningPrivateKey, msgToSign)
-
-	hs := &models.Handshake{
-		Id:    base64.RawURLEncoding.EncodeToString(self.SigningPublicKey),
-		Enc:   base64.RawURLEncoding.EncodeToString(ephPub[:]),
-		Sig:   base64.RawURLEncoding.EncodeToString(signature),
-		Nonce: base64.RawURLEncoding.EncodeToString(nonce),
+	hello, err := proto.Marshal(&models.Handshake{
+		Id:         base64.RawURLEncoding.EncodeToString(self.SigningPublicKey),
+		Enc:        base64.RawURLEncoding.EncodeToString(ephPub[:]),
+		Nonce:      base64.RawURLEncoding.EncodeToString(nonce),
+		Version:    ProtocolVersion,
+		MinVersion: MinProtocolVersion,
+		Features:   supportedFeatures,
+	})
+	if err != nil {
+		return nil, "", err
 	}
 
+	var peerHello []byte
 	if isInitiator {
-		if err := sendProto(conn, hs); err != nil {
+		if err := writeFrame(conn, hello); err != nil {
+			return nil, "", err
+		}
+		if peerHello, err = readFrame(conn); err != nil {
+			return nil, "", err
+		}
+	} else {
+		if peerHello, err = readFrame(conn); err != nil {
+			return nil, "", err
+		}
+		if err := writeFrame(conn, hello); err != nil {
 			return nil, "", err
 		}
 	}
 
 	peerHS := &models.Handshake{}
-	if err := recvProto(conn, peerHS); err != nil {
+	if err := proto.Unmarshal(peerHello, peerHS); err != nil {
 		return nil, "", err
 	}
-
-	if !isInitiator {
-		if err := sendProto(conn, hs); err != nil {
-			return nil, "", err
-		}
+	version, err := negotiateVersion(peerHS)
+	if err != nil {
+		return nil, "", err
 	}
 
 	peerPub, err := base64.RawURLEncoding.DecodeString(peerHS.Id)
 	if err != nil || len(peerPub) != ed25519.PublicKeySize {
 		return nil, "", errors.New("invalid peer public key")
 	}
-
 	peerEncPub, err := base64.RawURLEncoding.DecodeString(peerHS.Enc)
 	if err != nil || len(peerEncPub) != 32 {
 		return nil, "", errors.New("invalid peer encryption key")
 	}
-	peerNonce, err := base64.RawURLEncoding.DecodeString(peerHS.Nonce)
-	if err != nil {
-		return nil, "", err
+	if bytes.Equal(peerPub, self.SigningPublicKey) {
+		return nil, "", errors.New("handshake reflected back to us")
 	}
-	peerSig, err := base64.RawURLEncoding.DecodeString(peerHS.Sig)
-	if err != nil {
-		return nil, "", err
+
+	// Both sides hash the messages in the same order, initiator first
+	ourRole, peerRole := roleResponder, roleInitiator
+	transcript := handshakeTranscript(peerHello, hello)
+	if isInitiator {
+		ourRole, peerRole = roleInitiator, roleResponder
+		transcript = handshakeTranscript(hello, peerHello)
 	}
 
-	msgToVerify := append(peerPub, peerEncPub...)
-	msgToVerify = append(msgToVerify, peerNonce...)
-	if !ed25519.Verify(ed25519.PublicKey(peerPub), msgToVerify, peerSig) {
+	auth := &models.HandshakeAuth{
+		Sig: ed25519.Sign(self.SigningPrivateKey, authMessage(ourRole, transcript)),
+	}
+	peerAuth := &models.HandshakeAuth{}
+	if isInitiator {
+		if err := sendProto(conn, auth); err != nil {
+			return nil, "", err
+		}
+		if err := recvProto(conn, peerAuth); err != nil {
+			return nil, "", err
+		}
+	} else {
+		if err := recvProto(conn, peerAuth); err != nil {
+			return nil, "", err
+		}
+	}
+	if !ed25519.Verify(ed25519.PublicKey(peerPub), authMessage(peerRole, transcript), peerAuth.GetSig()) {
 		return nil, "", errors.New("invalid handshake signature")
 	}
+	if !isInitiator {
+		// Only answer once the initiator proved its identity
+		if err := sendProto(conn, auth); err != nil {
+			return nil, "", err
+		}
+	}
 
-	var peerEnc [32]byte
-	copy(peerEnc[:], peerEncPub)
-	sharedSecret, err := curve25519.X25519(ephPriv[:], peerEnc[:])
+	sharedSecret, err := curve25519.X25519(ephPriv[:], peerEncPub)
 	if err != nil {
 		return nil, "", err
 	}
-	key := sha256.Sum256(sharedSecret)
-
-	block, err := aes.NewCipher(key[:])
+	initiatorKey, responderKey, err := deriveSessionKeys(sharedSecret, transcript)
 	if err != nil {
 		return nil, "", err
 	}
-	aead, err := cipher.NewGCM(block)
-	if err != nil {
+	sendKey, recvKey := responderKey, initiatorKey
+	if isInitiator {
+		sendKey, recvKey = initiatorKey, responderKey
+	}
+
+	sc := &SecureConn{
+		conn:      conn,
+		initiator: isInitiator,
+		version:   version,
+		features:  commonFeatures(peerHS.GetFeatures()),
+		sendKey:   sendKey,
+		recvKey:   recvKey,
+		policy:    DefaultRekeyPolicy,
+		keyUsage:  keyUsage{since: time.Now()},
+		closed:    make(chan struct{}),
+		pongReady: make(chan struct{}, 1),
+	}
+	if sc.send, err = newAEAD(sendKey); err != nil {
+		return nil, "", err
+	}
+	if sc.recv, err = newAEAD(recvKey); err != nil {
 		return nil, "", err
 	}
 
 	peerID := base64.RawURLEncoding.EncodeToString(peerPub)
-	return aead, peerID, nil
+	return sc, peerID, nil
 }
 
 func sendProto(w io.Writer, msg proto.Message) error {
@@ -181,24 +427,41 @@ func sendProto(w io.Writer, msg proto.Message) error {
 	if err != nil {
 		return err
 	}
+	return writeFrame(w, data)
+}
+
+func recvProto(r io.Reader, msg proto.Message) error {
+	buf, err := readFrame(r)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(buf, msg)
+}
+
+// writeFrame writes data with a 2-byte length prefix
+func writeFrame(w io.Writer, data []byte) error {
+	if len(data) > 0xFFFF {
+		return fmt.Errorf("handshake message of %d bytes too large", len(data))
+	}
 	lenBuf := make([]byte, 2)
 	lenBuf[0], lenBuf[1] = byte(len(data)>>8), byte(len(data))
 	if _, err := w.Write(lenBuf); err != nil {
 		return err
 	}
-	_, err = w.Write(data)
+	_, err := w.Write(data)
 	return err
 }
 
-func recvProto(r io.Reader, msg proto.Message) error {
+// readFrame reads data written by writeFrame
+func readFrame(r io.Reader) ([]byte, error) {
 	lenBuf := make([]byte, 2)
 	if _, err := io.ReadFull(r, lenBuf); err != nil {
-		return err
+		return nil, err
 	}
 	size := int(lenBuf[0])<<8 | int(lenBuf[1])
 	buf := make([]byte, size)
 	if _, err := io.ReadFull(r, buf); err != nil {
-		return err
+		return nil, err
 	}
-	return proto.Unmarshal(buf, msg)
+	return buf, nil
 }
@@ -0,0 +1,321 @@
+package comms
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/eglochon/simple-lan-messaging/models"
+	"github.com/eglochon/simple-lan-messaging/pkg/identity"
+)
+
+// tapConn keeps a copy of every write, and swallows writes while drop is
+// set, so tests can replay, lose or alter frames
+type tapConn struct {
+	net.Conn
+
+	mu     sync.Mutex
+	writes [][]byte
+	drop   bool
+}
+
+func (c *tapConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	c.writes = append(c.writes, slices.Clone(b))
+	drop := c.drop
+	c.mu.Unlock()
+	if drop {
+		return len(b), nil
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *tapConn) setDrop(drop bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.drop = drop
+}
+
+// last returns the most recent write
+func (c *tapConn) last() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.writes[len(c.writes)-1]
+}
+
+func newTestIdentity(t *testing.T) *identity.Identity {
+	t.Helper()
+	id, err := identity.NewIdentity()
+	if err != nil {
+		t.Fatalf("NewIdentity: %v", err)
+	}
+	return id
+}
+
+// securePipe runs the handshake over an in-memory pipe and returns both
+// ends; writes of the initiator go through the returned tap
+func securePipe(t *testing.T) (initiator, responder *SecureConn, tap *tapConn) {
+	t.Helper()
+	initiatorID, responderID := newTestIdentity(t), newTestIdentity(t)
+	a, b := net.Pipe()
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	tap = &tapConn{Conn: a}
+
+	type result struct {
+		conn   *SecureConn
+		peerID string
+		err    error
+	}
+	accepted := make(chan result, 1)
+	go func() {
+		conn, peerID, err := performHandshake(b, responderID, false)
+		accepted <- result{conn, peerID, err}
+	}()
+
+	initiator, peerID, err := performHandshake(tap, initiatorID, true)
+	if err != nil {
+		t.Fatalf("initiator handshake: %v", err)
+	}
+	res := <-accepted
+	if res.err != nil {
+		t.Fatalf("responder handshake: %v", res.err)
+	}
+	if peerID != responderID.GetID() {
+		t.Errorf("initiator sees peer %s, want %s", peerID, responderID.GetID())
+	}
+	if res.peerID != initiatorID.GetID() {
+		t.Errorf("responder sees peer %s, want %s", res.peerID, initiatorID.GetID())
+	}
+	return initiator, res.conn, tap
+}
+
+// goWrite runs write in the background, as writes to a pipe block until read
+func goWrite(write func() error) <-chan error {
+	done := make(chan error, 1)
+	go func() { done <- write() }()
+	return done
+}
+
+func TestHandshake(t *testing.T) {
+	initiator, responder, _ := securePipe(t)
+
+	for _, conn := range []*SecureConn{initiator, responder} {
+		if conn.Version() != ProtocolVersion {
+			t.Errorf("negotiated version %d, want %d", conn.Version(), ProtocolVersion)
+		}
+		if !slices.Equal(conn.Features(), supportedFeatures) {
+			t.Errorf("negotiated features %v, want %v", conn.Features(), supportedFeatures)
+		}
+	}
+
+	// Each direction has its own key
+	for _, tc := range []struct {
+		name     string
+		from, to *SecureConn
+	}{
+		{"initiator to responder", initiator, responder},
+		{"responder to initiator", responder, initiator},
+	} {
+		msg := []byte("hello " + tc.name)
+		written := goWrite(func() error { return tc.from.WriteEncrypted(msg) })
+		got, err := tc.to.ReadEncrypted()
+		if err != nil {
+			t.Fatalf("%s: read: %v", tc.name, err)
+		}
+		if err := <-written; err != nil {
+			t.Fatalf("%s: write: %v", tc.name, err)
+		}
+		if !bytes.Equal(got, msg) {
+			t.Errorf("%s: got %q, want %q", tc.name, got, msg)
+		}
+	}
+}
+
+func TestReadRejectsReplayedFrame(t *testing.T) {
+	initiator, responder, tap := securePipe(t)
+
+	written := goWrite(func() error { return initiator.WriteEncrypted([]byte("pay 10")) })
+	if _, err := responder.ReadEncrypted(); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if err := <-written; err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	frame := tap.last()
+	go tap.Conn.Write(frame)
+	_, err := responder.ReadEncrypted()
+	var seqErr *SequenceError
+	if !errors.As(err, &seqErr) || !seqErr.Replay() {
+		t.Fatalf("replayed frame: got %v, want a replay SequenceError", err)
+	}
+	if seqErr.Got != 0 || seqErr.Expected != 1 {
+		t.Errorf("got sequence %d, expected %d; want 0 and 1", seqErr.Got, seqErr.Expected)
+	}
+}
+
+func TestReadRejectsMissingFrame(t *testing.T) {
+	initiator, responder, tap := securePipe(t)
+
+	tap.setDrop(true)
+	if err := initiator.WriteEncrypted([]byte("lost")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	tap.setDrop(false)
+
+	go initiator.WriteEncrypted([]byte("next"))
+	_, err := responder.ReadEncrypted()
+	var seqErr *SequenceError
+	if !errors.As(err, &seqErr) || seqErr.Replay() {
+		t.Fatalf("frame after a gap: got %v, want a gap SequenceError", err)
+	}
+}
+
+func TestReadRejectsTamperedFrame(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		at   func(frame []byte) int
+	}{
+		{"sequence number", func([]byte) int { return 2 + seqSize - 1 }},
+		{"frame kind", func([]byte) int { return 2 + seqSize }},
+		{"ciphertext", func([]byte) int { return 2 + headerSize }},
+		{"tag", func(frame []byte) int { return len(frame) - 1 }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			initiator, responder, tap := securePipe(t)
+
+			tap.setDrop(true)
+			if err := initiator.WriteEncrypted([]byte("pay 10")); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			frame := slices.Clone(tap.last())
+			frame[tc.at(frame)] ^= 1
+
+			go tap.Conn.Write(frame)
+			if _, err := responder.ReadEncrypted(); !errors.Is(err, ErrTamperedFrame) {
+				t.Fatalf("got %v, want ErrTamperedFrame", err)
+			}
+		})
+	}
+}
+
+func TestRekey(t *testing.T) {
+	for _, tc := range []struct {
+		policy RekeyPolicy
+		frames int
+		rekeys uint64
+	}{
+		{RekeyPolicy{}, 10, 0},
+		{RekeyPolicy{Messages: 3}, 10, 3},
+		{RekeyPolicy{Bytes: 100}, 10, 4}, // every second frame of 50 bytes
+		{RekeyPolicy{Messages: 1}, 5, 4},
+	} {
+		t.Run(fmt.Sprintf("%+v", tc.policy), func(t *testing.T) {
+			initiator, responder, _ := securePipe(t)
+			initiator.SetRekeyPolicy(tc.policy)
+
+			written := goWrite(func() error {
+				for i := range tc.frames {
+					if err := initiator.WriteEncrypted(bytes.Repeat([]byte{byte(i)}, 50)); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			for i := range tc.frames {
+				got, err := responder.ReadEncrypted()
+				if err != nil {
+					t.Fatalf("frame %d: %v", i, err)
+				}
+				if !bytes.Equal(got, bytes.Repeat([]byte{byte(i)}, 50)) {
+					t.Fatalf("frame %d: got %x", i, got)
+				}
+			}
+			if err := <-written; err != nil {
+				t.Fatalf("write: %v", err)
+			}
+
+			if sent := initiator.Stats().RekeysSent; sent != tc.rekeys {
+				t.Errorf("initiator rekeyed %d times, want %d", sent, tc.rekeys)
+			}
+			if received := responder.Stats().RekeysReceived; received != tc.rekeys {
+				t.Errorf("responder followed %d rekeys, want %d", received, tc.rekeys)
+			}
+		})
+	}
+}
+
+func TestRekeyRetiresOldKey(t *testing.T) {
+	initiator, responder, tap := securePipe(t)
+	initiator.SetRekeyPolicy(RekeyPolicy{Messages: 1})
+	oldKey := slices.Clone(initiator.sendKey)
+
+	// The second write rekeys first, so it goes out under a new key
+	written := goWrite(func() error {
+		if err := initiator.WriteEncrypted([]byte("one")); err != nil {
+			return err
+		}
+		return initiator.WriteEncrypted([]byte("two"))
+	})
+	for range 2 {
+		if _, err := responder.ReadEncrypted(); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+	}
+	if err := <-written; err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	// A frame sealed under the old key with the next sequence number
+	stale, err := newAEAD(oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	initiator.SetRekeyPolicy(RekeyPolicy{})
+	initiator.send = stale
+	tap.setDrop(true)
+	if err := initiator.WriteEncrypted([]byte("three")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	frame := tap.last()
+
+	go tap.Conn.Write(frame)
+	if _, err := responder.ReadEncrypted(); !errors.Is(err, ErrTamperedFrame) {
+		t.Fatalf("frame under a retired key: got %v, want ErrTamperedFrame", err)
+	}
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		peer    *models.Handshake
+		want    uint32
+		wantErr bool
+	}{
+		{"same range", &models.Handshake{Version: ProtocolVersion, MinVersion: MinProtocolVersion}, ProtocolVersion, false},
+		{"newer peer", &models.Handshake{Version: ProtocolVersion + 2, MinVersion: MinProtocolVersion}, ProtocolVersion, false},
+		{"legacy peer", &models.Handshake{}, 0, true},
+		{"older peer", &models.Handshake{Version: MinProtocolVersion - 1, MinVersion: 1}, 0, true},
+		{"peer requires newer", &models.Handshake{Version: ProtocolVersion + 2, MinVersion: ProtocolVersion + 1}, 0, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := negotiateVersion(tc.peer)
+			if tc.wantErr {
+				if !errors.Is(err, ErrIncompatibleVersion) {
+					t.Fatalf("got version %d, error %v; want ErrIncompatibleVersion", got, err)
+				}
+				return
+			}
+			if err != nil || got != tc.want {
+				t.Fatalf("got version %d, error %v; want version %d", got, err, tc.want)
+			}
+		})
+	}
+}
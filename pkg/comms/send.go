@@ -5,43 +5,104 @@ import (
 	"fmt"
 
 	"github.com/eglochon/simple-lan-messaging/models"
+	"github.com/eglochon/simple-lan-messaging/pkg/history"
 	"google.golang.org/protobuf/proto"
 )
 
-// SendProto marshals and sends a protobuf message securely.
+var (
+	ErrPeerNotFound    = errors.New("peer not found")
+	ErrPeerUnreachable = errors.New("peer unreachable")
+	ErrClosed          = errors.New("peer manager closed")
+)
+
+// Send stamps env with an ID and timestamp if missing, then marshals and
+// sends it securely.
 func (pm *PeerManager) Send(peerID string, env *models.Envelope) error {
+	if err := stamp(env); err != nil {
+		return err
+	}
 	data, err := marshalProto(env)
 	if err != nil {
 		return err
 	}
-	return pm.sendMessage(peerID, data)
+	if err := pm.sendMessage(peerID, data); err != nil {
+		return err
+	}
+	pm.record(history.Outbound, peerID, env)
+	return nil
+}
+
+// sendFeature sends env like Send, but only over a connection on which both
+// sides negotiated feature
+func (pm *PeerManager) sendFeature(peerID, feature string, env *models.Envelope) error {
+	_, conn, err := pm.reach(peerID)
+	if err != nil {
+		return err
+	}
+	if !conn.HasFeature(feature) {
+		return fmt.Errorf("%w: %s lacks %s", ErrFeatureUnsupported, peerID, feature)
+	}
+	return pm.Send(peerID, env)
 }
 
 // SendMessage sends a raw encrypted message to a peer, connecting if needed.
 func (pm *PeerManager) sendMessage(peerID string, message []byte) error {
+	peer, _, err := pm.reach(peerID)
+	if err != nil {
+		return err
+	}
+
+	err = pm.writeTo(peer, message)
+	if errors.Is(err, errWriterClosed) {
+		// The connection was replaced while we waited, try the new one
+		err = pm.writeTo(peer, message)
+	}
+	if err != nil && !errors.Is(err, ErrMessageTooLarge) && !errors.Is(err, ErrSendQueueFull) {
+		return fmt.Errorf("%w: %w", ErrPeerUnreachable, err)
+	}
+	return err
+}
+
+// reach returns the peer and its connection, connecting if needed
+func (pm *PeerManager) reach(peerID string) (*Peer, *SecureConn, error) {
 	pm.mu.RLock()
 	peer, exists := pm.peers[peerID]
+	var conn *SecureConn
+	var presence Presence
+	if exists {
+		conn = peer.Conn
+		presence = peer.Presence
+	}
 	pm.mu.RUnlock()
 
 	if !exists {
-		return fmt.Errorf("peer not found: %s", peerID)
+		return nil, nil, fmt.Errorf("%w: %s", ErrPeerNotFound, peerID)
 	}
-
-	if peer.Conn == nil {
-		_, err := pm.Connect(peerID)
-		if err != nil {
-			return fmt.Errorf("connection failed: %w", err)
-		}
+	if conn != nil {
+		return peer, conn, nil
+	}
+	// Don't wait for a dial timeout on peers that went silent
+	if presence == PresenceOffline {
+		return nil, nil, fmt.Errorf("%w: %s is offline", ErrPeerUnreachable, peerID)
+	}
+	conn, err := pm.Connect(peerID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: connection failed: %w", ErrPeerUnreachable, err)
 	}
+	return peer, conn, nil
+}
 
+// writeTo hands data to the writer of the peer's current connection and
+// waits for it to be written
+func (pm *PeerManager) writeTo(peer *Peer, data []byte) error {
 	pm.mu.RLock()
-	defer pm.mu.RUnlock()
+	writer := peer.writer
+	pm.mu.RUnlock()
 
-	if peer.Conn == nil {
-		return errors.New("no active connection after connect")
+	if writer == nil {
+		return errors.New("no active connection")
 	}
-
-	return peer.Conn.WriteEncrypted(message)
+	return writer.write(data)
 }
 
 func marshalProto(msg any) ([]byte, error) {
@@ -0,0 +1,63 @@
+package comms
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/eglochon/simple-lan-messaging/models"
+)
+
+// Wire protocol versions. A connection uses the highest version both sides
+// speak; peers whose range doesn't overlap ours are refused.
+//
+//  1. versioned handshake with features
+//  2. signed handshake transcript and per-direction session keys
+//  3. frames carry a sequence number header authenticated with the payload
+//  4. frame kinds for in-band rekeying
+const (
+	ProtocolVersion    = 4
+	MinProtocolVersion = 4 // every version changed the handshake or the frame layout
+)
+
+// Optional capabilities announced in the handshake
+const (
+	FeatureChunking     = "chunking"
+	FeaturePubSub       = "pubsub"
+	FeatureReceipts     = "receipts"
+	FeatureFileTransfer = "file-transfer"
+	FeatureKeepalive    = "keepalive"
+)
+
+// supportedFeatures is what this build announces to peers
+var supportedFeatures = []string{FeatureChunking, FeaturePubSub, FeatureReceipts, FeatureFileTransfer, FeatureKeepalive}
+
+var (
+	ErrIncompatibleVersion = errors.New("incompatible protocol version")
+	ErrFeatureUnsupported  = errors.New("feature not supported by peer")
+)
+
+// negotiateVersion picks the version to use with a peer, or explains why there is none
+func negotiateVersion(peer *models.Handshake) (uint32, error) {
+	if peer.GetVersion() < MinProtocolVersion {
+		if peer.GetVersion() == 0 {
+			return 0, fmt.Errorf("%w: peer runs a legacy client without versioning, need at least version %d", ErrIncompatibleVersion, MinProtocolVersion)
+		}
+		return 0, fmt.Errorf("%w: peer speaks up to version %d, need at least version %d", ErrIncompatibleVersion, peer.GetVersion(), MinProtocolVersion)
+	}
+	if peer.GetMinVersion() > ProtocolVersion {
+		return 0, fmt.Errorf("%w: peer requires at least version %d, we speak up to version %d", ErrIncompatibleVersion, peer.GetMinVersion(), ProtocolVersion)
+	}
+	return min(peer.GetVersion(), ProtocolVersion), nil
+}
+
+// commonFeatures returns the features supported by both sides, in our order
+func commonFeatures(peerFeatures []string) []string {
+	var common []string
+	for _, feature := range supportedFeatures {
+		if slices.Contains(peerFeatures, feature) {
+			common = append(common, feature)
+		}
+	}
+	return common
+}
@@ -0,0 +1,40 @@
+package comms
+
+import (
+	"log"
+	"time"
+)
+
+// securityWarningInterval is how often the same peer may be warned about,
+// as it repeats the offending announcement every announce interval
+const securityWarningInterval = time.Minute
+
+// OnSecurityWarning registers a callback for peers accepted despite a
+// suspicious identity, such as a name pinned to another key
+func (pm *PeerManager) OnSecurityWarning(fn func(peerID string, err error)) {
+	pm.onSecurity = fn
+}
+
+// securityWarning reports err for peerID unless it was reported recently
+func (pm *PeerManager) securityWarning(peerID string, err error) {
+	now := time.Now()
+	pm.warnMu.Lock()
+	for id, at := range pm.warnedAt {
+		if now.Sub(at) >= securityWarningInterval {
+			delete(pm.warnedAt, id)
+		}
+	}
+	_, recent := pm.warnedAt[peerID]
+	if !recent {
+		pm.warnedAt[peerID] = now
+	}
+	pm.warnMu.Unlock()
+	if recent {
+		return
+	}
+
+	log.Printf("[SECURITY WARNING] %v", err)
+	if pm.onSecurity != nil {
+		pm.onSecurity(peerID, err)
+	}
+}
@@ -0,0 +1,111 @@
+package comms
+
+import (
+	"errors"
+	"log"
+	"sync"
+)
+
+// sendQueueSize bounds the messages waiting for a peer's writer
+const sendQueueSize = 128
+
+var (
+	ErrSendQueueFull = errors.New("send queue full")
+	errWriterClosed  = errors.New("connection closed")
+)
+
+// connWriter owns all writes to one connection, so frames from concurrent
+// senders never interleave
+type connWriter struct {
+	conn  *SecureConn
+	queue chan *writeRequest
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+type writeRequest struct {
+	data []byte
+	done chan error
+}
+
+func newConnWriter(pm *PeerManager, conn *SecureConn) *connWriter {
+	w := &connWriter{
+		conn:   conn,
+		queue:  make(chan *writeRequest, sendQueueSize),
+		closed: make(chan struct{}),
+	}
+	if !pm.spawn(func() { w.run(pm) }) {
+		w.close()
+	}
+	return w
+}
+
+func (w *connWriter) run(pm *PeerManager) {
+	for {
+		select {
+		case <-w.closed:
+			return
+		case <-pm.ctx.Done():
+			w.close()
+			return
+		case <-w.conn.pongReady:
+			if err := w.conn.writePong(); err != nil {
+				w.fail(err)
+				return
+			}
+		case req := <-w.queue:
+			err := pm.writeMessage(w.conn, req.data)
+			req.done <- err
+			if err != nil && !errors.Is(err, ErrMessageTooLarge) {
+				w.fail(err)
+				return
+			}
+		}
+	}
+}
+
+// fail stops the writer after a write error. A partly written frame leaves
+// the stream unusable. A retired connection is still being read, so closing
+// it is left to the reader.
+func (w *connWriter) fail(err error) {
+	if !w.conn.retired.Load() {
+		log.Printf("[WARN] Write failed, closing connection: %v", err)
+		w.conn.Close()
+	}
+	w.close()
+}
+
+// write queues data and waits until it was written. It fails right away
+// with ErrSendQueueFull instead of blocking when the peer can't keep up.
+func (w *connWriter) write(data []byte) error {
+	req := &writeRequest{data: data, done: make(chan error, 1)}
+	select {
+	case <-w.closed:
+		return errWriterClosed
+	default:
+	}
+	select {
+	case w.queue <- req:
+	default:
+		return ErrSendQueueFull
+	}
+
+	select {
+	case err := <-req.done:
+		return err
+	case <-w.closed:
+		// The request may have been written just before closing
+		select {
+		case err := <-req.done:
+			return err
+		default:
+			return errWriterClosed
+		}
+	}
+}
+
+// close stops the writer; queued messages fail with errWriterClosed
+func (w *connWriter) close() {
+	w.closeOnce.Do(func() { close(w.closed) })
+}
@@ -0,0 +1,290 @@
+package contacts
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Trust describes how much the user vouched for a contact's key
+type Trust string
+
+const (
+	Unverified Trust = "unverified" // pinned on first use, never checked
+	Verified   Trust = "verified"   // key compared out of band
+	Trusted    Trust = "trusted"    // verified and explicitly trusted
+	Blocked    Trust = "blocked"    // never talk to this key
+)
+
+// ErrBlocked is returned when a blocked contact shows up
+var ErrBlocked = errors.New("contact is blocked")
+
+// KeyChangedError reports a known display name presented with a different key
+type KeyChangedError struct {
+	Name      string
+	PinnedID  string // key we pinned for Name
+	PresentID string // key that now claims Name
+	Enforced  bool   // true if the new key must be rejected
+}
+
+func (e *KeyChangedError) Error() string {
+	return fmt.Sprintf("name %q is pinned to key %s but was presented with key %s", e.Name, e.PinnedID, e.PresentID)
+}
+
+// NameChangedError reports a known key announcing a name other than the one
+// pinned for it. The pinned name is kept until the user renames the contact.
+type NameChangedError struct {
+	ID          string
+	PinnedName  string // name we pinned for ID
+	PresentName string // name ID now announces
+}
+
+func (e *NameChangedError) Error() string {
+	return fmt.Sprintf("key %s is pinned as %q but announced the name %q", e.ID, e.PinnedName, e.PresentName)
+}
+
+// Contact is a pinned Ed25519 key together with the name it was first seen with
+type Contact struct {
+	ID        string    `json:"id"` // base64 Ed25519 public key
+	Name      string    `json:"name"`
+	Trust     Trust     `json:"trust"`
+	FirstSeen time.Time `json:"first_seen"`
+}
+
+// Store is a persistent trust-on-first-use contact list
+type Store struct {
+	// Strict rejects every key change, not only those of verified contacts
+	Strict bool
+
+	path     string
+	mu       sync.Mutex
+	contacts map[string]*Contact // ID → contact
+}
+
+// Open loads the contact store at path, starting empty if the file doesn't exist
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:     path,
+		contacts: make(map[string]*Contact),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*Contact
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("invalid contact store %s: %w", path, err)
+	}
+	for _, c := range list {
+		s.contacts[c.ID] = c
+	}
+	return s, nil
+}
+
+// DefaultPath returns the contact store location in the working directory
+func DefaultPath() string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "contacts.json"
+	}
+	return filepath.Join(cwd, "contacts.json")
+}
+
+// Observe records that key id presented itself as name. Unknown keys are
+// pinned on first use together with name, which then stays fixed. It returns
+// [ErrBlocked] for blocked keys, a [*KeyChangedError] when name is already
+// pinned to another key and a [*NameChangedError] when a known key announces
+// a new name.
+func (s *Store) Observe(id, name string) (Contact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c, exists := s.contacts[id]; exists {
+		if c.Trust == Blocked {
+			return *c, ErrBlocked
+		}
+		if err := s.conflict(id, name, c.FirstSeen); err != nil {
+			return *c, err
+		}
+		if name != "" && c.Name != name {
+			return *c, &NameChangedError{ID: id, PinnedName: c.Name, PresentName: name}
+		}
+		return *c, nil
+	}
+
+	now := time.Now()
+	conflictErr := s.conflict(id, name, now)
+	var keyErr *KeyChangedError
+	if errors.As(conflictErr, &keyErr) && keyErr.Enforced {
+		return Contact{}, conflictErr
+	}
+
+	c := &Contact{
+		ID:        id,
+		Name:      name,
+		Trust:     Unverified,
+		FirstSeen: now,
+	}
+	s.contacts[id] = c
+	if err := s.save(); err != nil {
+		return *c, err
+	}
+	return *c, conflictErr
+}
+
+// IsBlocked reports whether id belongs to a blocked contact
+func (s *Store) IsBlocked(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, exists := s.contacts[id]
+	return exists && c.Trust == Blocked
+}
+
+// Get returns the contact pinned for id
+func (s *Store) Get(id string) (Contact, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, exists := s.contacts[id]
+	if !exists {
+		return Contact{}, false
+	}
+	return *c, true
+}
+
+// Lookup resolves a contact by exact ID, name or unambiguous ID prefix
+func (s *Store) Lookup(ref string) (Contact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c, exists := s.contacts[ref]; exists {
+		return *c, nil
+	}
+
+	var matches []*Contact
+	for _, c := range s.contacts {
+		if strings.EqualFold(c.Name, ref) || strings.HasPrefix(c.ID, ref) {
+			matches = append(matches, c)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return Contact{}, fmt.Errorf("contact not found: %s", ref)
+	case 1:
+		return *matches[0], nil
+	default:
+		return Contact{}, fmt.Errorf("ambiguous contact %q matches %d contacts", ref, len(matches))
+	}
+}
+
+// SetTrust changes the trust level of a pinned contact
+func (s *Store) SetTrust(id string, trust Trust) error {
+	switch trust {
+	case Unverified, Verified, Trusted, Blocked:
+	default:
+		return fmt.Errorf("unknown trust level %q", trust)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, exists := s.contacts[id]
+	if !exists {
+		return fmt.Errorf("contact not found: %s", id)
+	}
+	c.Trust = trust
+	return s.save()
+}
+
+// Rename changes the name pinned for a contact
+func (s *Store) Rename(id, name string) error {
+	if name == "" {
+		return errors.New("empty contact name")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, exists := s.contacts[id]
+	if !exists {
+		return fmt.Errorf("contact not found: %s", id)
+	}
+	c.Name = name
+	return s.save()
+}
+
+// All returns every contact sorted by name
+func (s *Store) All() []Contact {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]Contact, 0, len(s.contacts))
+	for _, c := range s.contacts {
+		list = append(list, *c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name != list[j].Name {
+			return list[i].Name < list[j].Name
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// conflict returns a [*KeyChangedError] if name was pinned to a key other
+// than id before firstSeen
+func (s *Store) conflict(id, name string, firstSeen time.Time) error {
+	if name == "" {
+		return nil
+	}
+	for _, c := range s.contacts {
+		if c.ID == id || !strings.EqualFold(c.Name, name) || c.Trust == Blocked {
+			continue
+		}
+		if !c.FirstSeen.Before(firstSeen) {
+			continue
+		}
+		return &KeyChangedError{
+			Name:      name,
+			PinnedID:  c.ID,
+			PresentID: id,
+			Enforced:  s.Strict || c.Trust == Verified || c.Trust == Trusted,
+		}
+	}
+	return nil
+}
+
+// save atomically rewrites the store file; callers must hold s.mu
+func (s *Store) save() error {
+	list := make([]*Contact, 0, len(s.contacts))
+	for _, c := range s.contacts {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,261 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/eglochon/simple-lan-messaging/models"
+	"github.com/eglochon/simple-lan-messaging/pkg/comms"
+	"github.com/eglochon/simple-lan-messaging/pkg/identity"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// event is one line of the message stream
+type event struct {
+	Peer     string          `json:"peer"`
+	Envelope json.RawMessage `json:"envelope"` // protobuf JSON encoding of models.Envelope
+}
+
+type identityInfo struct {
+	ID            string   `json:"id"`
+	Name          string   `json:"name"`
+	Fingerprint   string   `json:"fingerprint"`
+	Subscriptions []string `json:"subscriptions"`
+}
+
+type peerInfo struct {
+	ID        string       `json:"id"`
+	Name      string       `json:"name,omitempty"`
+	Addr      string       `json:"addr"`
+	Connected bool         `json:"connected"`
+	Presence  string       `json:"presence"`
+	LastSeen  time.Time    `json:"last_seen"`
+	Topics    []string     `json:"topics,omitempty"`
+	RTTMillis float64      `json:"rtt_ms,omitempty"`
+	Session   *sessionInfo `json:"session,omitempty"`
+}
+
+// sessionInfo describes the live connection to a peer
+type sessionInfo struct {
+	Version        uint32   `json:"version"`
+	Features       []string `json:"features"`
+	RekeysSent     uint64   `json:"rekeys_sent"`
+	RekeysReceived uint64   `json:"rekeys_received"`
+}
+
+// sendRequest carries either plain text content or a full envelope
+type sendRequest struct {
+	Peer     string          `json:"peer"`
+	Content  string          `json:"content,omitempty"`
+	Envelope json.RawMessage `json:"envelope,omitempty"`
+}
+
+type sendResponse struct {
+	ID     string             `json:"id"`
+	Status comms.OutboxStatus `json:"status"`
+}
+
+type publishRequest struct {
+	Topic   string `json:"topic"`
+	Content string `json:"content"`
+}
+
+type subscribeRequest struct {
+	Topic string `json:"topic"`
+}
+
+func (s *Server) handleIdentity(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, identityInfo{
+		ID:            s.self.GetID(),
+		Name:          s.name,
+		Fingerprint:   identity.FormatDigits(s.self.Fingerprint()),
+		Subscriptions: s.pm.Subscriptions(),
+	})
+}
+
+func (s *Server) handlePeers(w http.ResponseWriter, r *http.Request) {
+	peers := []peerInfo{}
+	for _, peer := range s.pm.AllPeers() {
+		if peer.ID == s.self.GetID() {
+			continue
+		}
+		info := peerInfo{
+			ID:        peer.ID,
+			Name:      peer.Name,
+			Addr:      peer.Addr(),
+			Connected: peer.IsConnected(),
+			Presence:  string(peer.Presence),
+			LastSeen:  peer.LastSeen,
+			Topics:    peer.Topics,
+			RTTMillis: float64(peer.RTT) / float64(time.Millisecond),
+		}
+		if session := peer.Session; session != nil {
+			info.Session = &sessionInfo{
+				Version:        session.Version,
+				Features:       session.Features,
+				RekeysSent:     session.Stats.RekeysSent,
+				RekeysReceived: session.Stats.RekeysReceived,
+			}
+		}
+		peers = append(peers, info)
+	}
+	writeJSON(w, http.StatusOK, peers)
+}
+
+func (s *Server) handleSend(w http.ResponseWriter, r *http.Request) {
+	var req sendRequest
+	if !s.decodeRequest(w, r, &req) {
+		return
+	}
+	if req.Peer == "" || (req.Content == "") == (req.Envelope == nil) {
+		writeError(w, http.StatusBadRequest, errors.New("peer and exactly one of content or envelope are required"))
+		return
+	}
+
+	peerID := req.Peer
+	if peer, err := s.pm.Lookup(req.Peer); err == nil {
+		peerID = peer.ID
+	} else if _, keyErr := identity.NewRemoteIdentity(req.Peer); keyErr != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	env := &models.Envelope{
+		Type:    "message",
+		Payload: &models.Envelope_Message{Message: &models.TopicMessage{Content: req.Content}},
+	}
+	if req.Envelope != nil {
+		env = &models.Envelope{}
+		if err := protojson.Unmarshal(req.Envelope, env); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		// Protocol frames such as receipts and file chunks are the node's business
+		if _, ok := env.Payload.(*models.Envelope_Message); !ok {
+			writeError(w, http.StatusBadRequest, errors.New("only message envelopes can be sent"))
+			return
+		}
+	}
+
+	id, status, err := s.pm.SendOrQueue(peerID, env, s.OutboxTTL)
+	if err != nil {
+		writeError(w, sendErrorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sendResponse{ID: id, Status: status})
+}
+
+func (s *Server) handlePublish(w http.ResponseWriter, r *http.Request) {
+	var req publishRequest
+	if !s.decodeRequest(w, r, &req) {
+		return
+	}
+	id, err := s.pm.Publish(req.Topic, req.Content)
+	if err != nil {
+		writeError(w, sendErrorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sendResponse{ID: id, Status: comms.OutboxSent})
+}
+
+func (s *Server) handleSubscribe(w http.ResponseWriter, r *http.Request) {
+	var req subscribeRequest
+	if !s.decodeRequest(w, r, &req) {
+		return
+	}
+	// Subscribing only tells peers to send us the topic, the messages reach
+	// the streams through Deliver and the application through OnMessage like
+	// all others
+	s.subMu.Lock()
+	var err error
+	if !slices.Contains(s.pm.Subscriptions(), req.Topic) {
+		err = s.pm.Subscribe(req.Topic, nil)
+	}
+	s.subMu.Unlock()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.pm.Subscriptions())
+}
+
+// handleMessages streams received envelopes as newline-delimited JSON until
+// the client disconnects
+func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming unsupported"))
+		return
+	}
+
+	stream := make(chan []byte, streamBuffer)
+	s.mu.Lock()
+	s.streams[stream] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.streams, stream)
+		s.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case line := <-stream:
+			if _, err := w.Write(line); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// decodeRequest reads the JSON request body into v, replying with an error
+// and returning false if it can't. Bodies are limited to twice the message
+// size limit, which leaves room for JSON escaping.
+func (s *Server) decodeRequest(w http.ResponseWriter, r *http.Request, v any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, 2*int64(s.pm.MaxMessageSize())+4<<10)
+	err := json.NewDecoder(r.Body).Decode(v)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, err)
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err)
+	default:
+		return true
+	}
+	return false
+}
+
+// sendErrorStatus maps delivery errors to HTTP status codes
+func sendErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, comms.ErrPeerNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, comms.ErrPeerUnreachable):
+		return http.StatusBadGateway
+	case errors.Is(err, comms.ErrMessageTooLarge):
+		return http.StatusRequestEntityTooLarge
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,181 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/eglochon/simple-lan-messaging/models"
+	"github.com/eglochon/simple-lan-messaging/pkg/comms"
+	"github.com/eglochon/simple-lan-messaging/pkg/identity"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// streamBuffer is how many events a slow stream client may lag behind before events are dropped
+const streamBuffer = 64
+
+// Server exposes a PeerManager as HTTP+JSON on a Unix domain socket that
+// only the owning user can connect to
+type Server struct {
+	OutboxTTL time.Duration // how long messages for offline peers stay queued, 0 for the default
+
+	path string
+	pm   *comms.PeerManager
+	self *identity.Identity
+	name string
+
+	listener net.Listener
+	http     *http.Server
+
+	mu      sync.Mutex
+	streams map[chan []byte]struct{}
+	subMu   sync.Mutex // makes subscribing to the same topic twice a no-op
+}
+
+// NewServer creates a control server listening on the socket at path
+func NewServer(path string, pm *comms.PeerManager, self *identity.Identity, name string) *Server {
+	s := &Server{
+		path:    path,
+		pm:      pm,
+		self:    self,
+		name:    name,
+		streams: make(map[chan []byte]struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/identity", s.handleIdentity)
+	mux.HandleFunc("GET /v1/peers", s.handlePeers)
+	mux.HandleFunc("POST /v1/send", s.handleSend)
+	mux.HandleFunc("POST /v1/publish", s.handlePublish)
+	mux.HandleFunc("POST /v1/subscribe", s.handleSubscribe)
+	mux.HandleFunc("GET /v1/messages", s.handleMessages)
+	s.http = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	return s
+}
+
+// DefaultPath returns the control socket location in the working directory
+func DefaultPath() string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "control.sock"
+	}
+	return filepath.Join(cwd, "control.sock")
+}
+
+// Start creates the socket, restricts it to the current user and serves requests
+func (s *Server) Start() error {
+	if err := removeStaleSocket(s.path); err != nil {
+		return err
+	}
+	listener, err := listenPrivate(s.path)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+
+	go func() {
+		if err := s.http.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("[CONTROL] Serve error: %v", err)
+		}
+	}()
+	log.Printf("[CONTROL] Listening on %s", s.path)
+	return nil
+}
+
+// Stop closes the socket and all open streams
+func (s *Server) Stop() error {
+	if s.listener == nil {
+		return nil
+	}
+	err := s.http.Close()
+	os.Remove(s.path)
+	return err
+}
+
+// Deliver forwards a received envelope to all message streams. It is meant
+// for PeerManager.OnReceive, which sees topic messages handled elsewhere too.
+func (s *Server) Deliver(peerID string, env *models.Envelope) {
+	envelope, err := protojson.MarshalOptions{EmitDefaultValues: true}.Marshal(env)
+	if err != nil {
+		log.Printf("[CONTROL] Failed to encode message: %v", err)
+		return
+	}
+	line, err := json.Marshal(event{Peer: peerID, Envelope: envelope})
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for stream := range s.streams {
+		select {
+		case stream <- line:
+		default:
+			log.Printf("[CONTROL] Stream client too slow, dropping message from %s", peerID)
+		}
+	}
+}
+
+// DeliverReceipt forwards a receipt to all message streams
+func (s *Server) DeliverReceipt(peerID string, receipt *models.Receipt) {
+	s.Deliver(peerID, &models.Envelope{
+		Type:    "receipt",
+		Payload: &models.Envelope_Receipt{Receipt: receipt},
+	})
+}
+
+// listenPrivate creates the socket in a fresh directory only we can enter,
+// restricts it and only then moves it to path, so no other user can connect
+// while its permissions still follow the umask
+func listenPrivate(path string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".control-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "sock")
+	listener, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	// The socket is removed by Stop under its final name
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// removeStaleSocket deletes a socket left behind by a node that didn't shut
+// down cleanly, refusing to touch other files or a socket still in use
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another node", path)
+	}
+	return os.Remove(path)
+}
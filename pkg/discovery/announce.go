@@ -0,0 +1,98 @@
+package discovery
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/eglochon/simple-lan-messaging/models"
+	"github.com/eglochon/simple-lan-messaging/pkg/identity"
+	"google.golang.org/protobuf/proto"
+)
+
+// MaxAnnouncementAge is how far a signed announcement's timestamp may drift from our clock
+const MaxAnnouncementAge = 30 * time.Second
+
+var (
+	ErrUnsignedAnnouncement = errors.New("announcement is not signed")
+	ErrStaleAnnouncement    = errors.New("announcement timestamp outside accepted window")
+	ErrInvalidAnnouncement  = errors.New("invalid announcement signature")
+	ErrReplayedAnnouncement = errors.New("announcement already accepted")
+	ErrAddressMismatch      = errors.New("announcement sent from an address it doesn't list")
+)
+
+// NewAnnouncer returns a function producing a freshly stamped and signed
+// encoding of the message built by build, suitable for [NewDiscoveryService].
+func NewAnnouncer(build func() *models.Discovery, self *identity.Identity) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		announcement := build()
+		SignAnnouncement(announcement, self)
+		return proto.Marshal(announcement)
+	}
+}
+
+// SignAnnouncement stamps msg with the current time and signs it with self's key
+func SignAnnouncement(msg *models.Discovery, self *identity.Identity) {
+	msg.Timestamp = time.Now().UnixMilli()
+	msg.Sig = base64.RawURLEncoding.EncodeToString(self.SignMessage(announcementBytes(msg)))
+}
+
+// VerifyAnnouncement checks that msg is signed by the key in its id field and
+// that its timestamp is within [MaxAnnouncementAge] of now.
+func VerifyAnnouncement(msg *models.Discovery, now time.Time) error {
+	if msg.GetSig() == "" || msg.GetTimestamp() == 0 {
+		return ErrUnsignedAnnouncement
+	}
+
+	signedAt := time.UnixMilli(msg.GetTimestamp())
+	if signedAt.Before(now.Add(-MaxAnnouncementAge)) || signedAt.After(now.Add(MaxAnnouncementAge)) {
+		return fmt.Errorf("%w: signed at %s", ErrStaleAnnouncement, signedAt.Format(time.RFC3339))
+	}
+
+	remote, err := identity.NewRemoteIdentity(msg.GetId())
+	if err != nil {
+		return fmt.Errorf("invalid announcement id: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(msg.GetSig())
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return ErrInvalidAnnouncement
+	}
+	if !identity.VerifySignature(remote.PublicKey, announcementBytes(msg), sig) {
+		return ErrInvalidAnnouncement
+	}
+	return nil
+}
+
+// VerifySource checks that an announcement arrived from one of the addresses
+// its sender signed, so a replayed copy can't move the peer to another host.
+func VerifySource(msg *models.Discovery, ip net.IP) error {
+	for _, addr := range append([]string{msg.GetIp()}, msg.GetAddrs()...) {
+		if listed := net.ParseIP(addr); listed != nil && listed.Equal(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrAddressMismatch, ip)
+}
+
+// announcementBytes is the canonical, length-prefixed encoding of the signed fields
+func announcementBytes(msg *models.Discovery) []byte {
+	buf := []byte("slm-discovery-v1")
+	for _, field := range []string{msg.GetId(), msg.GetEnc(), msg.GetName(), msg.GetIp()} {
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(field)))
+		buf = append(buf, field...)
+	}
+	buf = binary.BigEndian.AppendUint32(buf, msg.GetPort())
+	buf = binary.BigEndian.AppendUint64(buf, uint64(msg.GetTimestamp()))
+	for _, list := range [][]string{msg.GetTopics(), msg.GetAddrs()} {
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(list)))
+		for _, item := range list {
+			buf = binary.BigEndian.AppendUint16(buf, uint16(len(item)))
+			buf = append(buf, item...)
+		}
+	}
+	return buf
+}
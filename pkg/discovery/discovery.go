@@ -1,104 +1,185 @@
 package discovery
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
 	"net"
+	"sync"
 	"time"
 
-	"github.com/eglochon/simple-lan-messaging/config"
 	"golang.org/x/net/ipv4"
 )
 
+// DefaultGroup is the multicast group announcements are sent to
+const DefaultGroup = "224.0.0.250:40400"
+
+// maxAnnouncementSize is the largest UDP payload, announcements grow with
+// the subscribed topics
+const maxAnnouncementSize = 65507
+
 type DiscoveryService struct {
-	Message   []byte
+	Announce  func() ([]byte, error) // builds the payload for each broadcast
 	Interval  time.Duration
-	running   bool
+	Group     string // multicast group:port, DefaultGroup if empty
 	onMessage func(data []byte, addr *net.UDPAddr)
 	selfAddr  *SelfAddress
+
+	groupAddr  *net.UDPAddr
+	listenConn *net.UDPConn
+	sendConns  []*net.UDPConn // one per interface in selfAddr
+	closeOnce  sync.Once
+	closeErr   error
+	done       chan struct{}
+	wg         sync.WaitGroup
 }
 
-// NewDiscoveryService creates a new instance
-func NewDiscoveryService(message []byte, interval time.Duration, onMessage func(data []byte, addr *net.UDPAddr)) (*DiscoveryService, error) {
-	selfAddr, err := NewSelfAddress()
-	if err != nil {
-		return nil, err
-	}
+// NewDiscoveryService creates a new instance announcing and listening on
+// every interface of selfAddr
+func NewDiscoveryService(selfAddr *SelfAddress, announce func() ([]byte, error), interval time.Duration, onMessage func(data []byte, addr *net.UDPAddr)) *DiscoveryService {
 	return &DiscoveryService{
-		Message:   message,
+		Announce:  announce,
 		Interval:  interval,
 		onMessage: onMessage,
 		selfAddr:  selfAddr,
-	}, nil
+		done:      make(chan struct{}),
+	}
 }
 
 func (d *DiscoveryService) SetInterval(interval time.Duration) {
 	d.Interval = interval
 }
 
-func (d *DiscoveryService) Start() {
-	d.running = true
+// Start joins the multicast group and begins listening and announcing.
+// Cancelling ctx closes the sockets.
+func (d *DiscoveryService) Start(ctx context.Context) error {
+	group := d.Group
+	if group == "" {
+		group = DefaultGroup
+	}
+	groupAddr, err := net.ResolveUDPAddr("udp4", group)
+	if err != nil {
+		return fmt.Errorf("resolve %s: %w", group, err)
+	}
+	d.groupAddr = groupAddr
+
+	if err := d.open(); err != nil {
+		d.closeConns()
+		d.listenConn, d.sendConns = nil, nil
+		return err
+	}
 
+	d.wg.Add(2)
 	go d.listen()
 	go d.broadcast()
+	context.AfterFunc(ctx, func() { d.shutdown() })
+	return nil
 }
 
-func (d *DiscoveryService) Stop() {
-	d.running = false
-}
+// open joins the group on every interface and opens a send socket bound to
+// each interface's address, so announcements reach every network with a
+// source address peers there can connect back to
+func (d *DiscoveryService) open() error {
+	interfaces := d.selfAddr.Interfaces()
+	if len(interfaces) == 0 {
+		return ErrNoInterface
+	}
 
-func (d *DiscoveryService) listen() {
-	addr, err := net.ResolveUDPAddr("udp", config.ANNOUNCE_ADDR)
+	var err error
+	d.listenConn, err = net.ListenMulticastUDP("udp4", &interfaces[0].Interface, d.groupAddr)
 	if err != nil {
-		fmt.Println("Resolve error:", err)
-		return
+		return fmt.Errorf("listen on %s: %w", d.groupAddr, err)
+	}
+	listener := ipv4.NewPacketConn(d.listenConn)
+	for _, addr := range interfaces[1:] {
+		if err := listener.JoinGroup(&addr.Interface, d.groupAddr); err != nil {
+			log.Printf("[WARN] Failed to join %s on %s: %v", d.groupAddr, addr.Interface.Name, err)
+		}
 	}
 
-	conn, err := net.ListenMulticastUDP("udp", nil, addr)
-	if err != nil {
-		fmt.Println("Listen error:", err)
-		return
+	for _, addr := range interfaces {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: addr.IP})
+		if err != nil {
+			return fmt.Errorf("announce on %s: %w", addr.Interface.Name, err)
+		}
+		d.sendConns = append(d.sendConns, conn)
+
+		packetConn := ipv4.NewPacketConn(conn)
+		if err := packetConn.SetMulticastInterface(&addr.Interface); err != nil {
+			return fmt.Errorf("announce on %s: %w", addr.Interface.Name, err)
+		}
+		if err := packetConn.SetMulticastLoopback(false); err != nil {
+			log.Printf("[WARN] Failed to disable multicast loopback: %v", err)
+		}
 	}
-	defer conn.Close()
+	return nil
+}
+
+// Close stops announcing, closes the sockets and waits for both loops to exit.
+func (d *DiscoveryService) Close() error {
+	err := d.shutdown()
+	d.wg.Wait()
+	return err
+}
 
-	buf := make([]byte, 1024)
+func (d *DiscoveryService) shutdown() error {
+	d.closeOnce.Do(func() {
+		close(d.done)
+		d.closeErr = d.closeConns()
+	})
+	return d.closeErr
+}
 
-	for d.running {
-		n, src, err := conn.ReadFromUDP(buf)
+func (d *DiscoveryService) closeConns() error {
+	var errs []error
+	if d.listenConn != nil {
+		errs = append(errs, d.listenConn.Close())
+	}
+	for _, conn := range d.sendConns {
+		errs = append(errs, conn.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (d *DiscoveryService) listen() {
+	defer d.wg.Done()
+
+	buf := make([]byte, maxAnnouncementSize)
+	for {
+		n, src, err := d.listenConn.ReadFromUDP(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
 			continue
 		}
 
-		if src.IP.String() != d.selfAddr.IP {
+		if !d.selfAddr.IsLocal(src.IP) {
 			d.onMessage(buf[:n], src)
 		}
 	}
 }
 
 func (d *DiscoveryService) broadcast() {
-	groupAddr, err := net.ResolveUDPAddr("udp4", config.ANNOUNCE_ADDR)
-	if err != nil {
-		fmt.Println("Broadcast resolve error:", err)
-		return
-	}
-
-	conn, err := net.DialUDP("udp4", nil, groupAddr)
-	if err != nil {
-		fmt.Println("Broadcast dial error:", err)
-		return
-	}
-	defer conn.Close()
+	defer d.wg.Done()
 
-	p := ipv4.NewPacketConn(conn)
-	err = p.SetMulticastLoopback(false)
-	if err != nil {
-		fmt.Println("Failed to disable loopback:", err)
-	}
-
-	for d.running {
-		_, err := conn.Write(d.Message)
+	for {
+		message, err := d.Announce()
 		if err != nil {
-			fmt.Println("Broadcast write error:", err)
+			log.Printf("[WARN] Failed to encode announcement: %v", err)
+		} else {
+			for _, conn := range d.sendConns {
+				if _, err := conn.WriteToUDP(message, d.groupAddr); err != nil && !errors.Is(err, net.ErrClosed) {
+					log.Printf("[WARN] Failed to send announcement from %s: %v", conn.LocalAddr(), err)
+				}
+			}
+		}
+
+		select {
+		case <-d.done:
+			return
+		case <-time.After(d.Interval):
 		}
-		time.Sleep(d.Interval)
 	}
 }
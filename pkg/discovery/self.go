@@ -5,21 +5,83 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"slices"
 )
 
+var ErrNoInterface = errors.New("no usable network interface")
+
+// InterfaceAddr is an IPv4 address of a local network interface
+type InterfaceAddr struct {
+	Interface net.Interface
+	IP        net.IP
+}
+
 type SelfAddress struct {
 	Hostname string
-	IP       string
+	IP       string          // primary address, the first of Addrs
+	Addrs    []InterfaceAddr // every address the node listens and announces on
 }
 
-func NewSelfAddress() (*SelfAddress, error) {
-	conn, err := net.Dial("udp4", "8.8.8.8:80")
+// NewSelfAddress picks the local addresses from the network interfaces,
+// without needing a route to anywhere. By default these are the IPv4
+// addresses of every interface that is up, multicast capable and not a
+// loopback, routable addresses before link-local ones. A non-empty iface
+// limits them to the named interface, a non-empty bind to that one address.
+func NewSelfAddress(iface, bind string) (*SelfAddress, error) {
+	var bindIP net.IP
+	if bind != "" {
+		bindIP = net.ParseIP(bind).To4()
+		if bindIP == nil {
+			return nil, fmt.Errorf("bind address %q is not an IPv4 address", bind)
+		}
+	}
+
+	ifaces, err := net.Interfaces()
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("list interfaces: %w", err)
 	}
-	defer conn.Close()
-	localAddr := conn.LocalAddr().(*net.UDPAddr)
-	strIP := localAddr.IP.String()
+	var addrs []InterfaceAddr
+	for _, ifi := range ifaces {
+		if (iface != "" && ifi.Name != iface) || ifi.Flags&net.FlagUp == 0 {
+			continue
+		}
+		// Interfaces that can't reach the LAN are only used when asked for
+		explicit := iface != "" || bindIP != nil
+		if !explicit && (ifi.Flags&net.FlagLoopback != 0 || ifi.Flags&net.FlagMulticast == 0) {
+			continue
+		}
+		ifaceAddrs, err := ifi.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range ifaceAddrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			ip := ipNet.IP.To4()
+			if ip == nil || (bindIP != nil && !ip.Equal(bindIP)) {
+				continue
+			}
+			addrs = append(addrs, InterfaceAddr{Interface: ifi, IP: ip})
+		}
+	}
+
+	switch {
+	case len(addrs) > 0:
+	case bindIP != nil:
+		return nil, fmt.Errorf("%w: no interface that is up has address %s", ErrNoInterface, bindIP)
+	case iface != "":
+		return nil, fmt.Errorf("%w: %s is down or has no IPv4 address", ErrNoInterface, iface)
+	default:
+		return nil, fmt.Errorf("%w: no multicast capable interface with an IPv4 address is up", ErrNoInterface)
+	}
+
+	// Self-assigned addresses only come first when there is nothing else,
+	// as on a LAN without a DHCP server
+	slices.SortStableFunc(addrs, func(a, b InterfaceAddr) int {
+		return boolOrder(a.IP.IsLinkLocalUnicast(), b.IP.IsLinkLocalUnicast())
+	})
 
 	hostname, err := os.Hostname()
 	if err != nil {
@@ -29,7 +91,8 @@ func NewSelfAddress() (*SelfAddress, error) {
 
 	return &SelfAddress{
 		Hostname: hostname,
-		IP:       strIP,
+		IP:       addrs[0].IP.String(),
+		Addrs:    addrs,
 	}, nil
 }
 
@@ -37,3 +100,32 @@ func NewSelfAddress() (*SelfAddress, error) {
 func (sa *SelfAddress) Addr(port uint16) string {
 	return fmt.Sprintf("%s:%d", sa.IP, port)
 }
+
+// Interfaces returns the first address of each interface in use, starting
+// with the primary one
+func (sa *SelfAddress) Interfaces() []InterfaceAddr {
+	var first []InterfaceAddr
+	for _, addr := range sa.Addrs {
+		if !slices.ContainsFunc(first, func(a InterfaceAddr) bool { return a.Interface.Index == addr.Interface.Index }) {
+			first = append(first, addr)
+		}
+	}
+	return first
+}
+
+// IsLocal reports whether ip is one of our addresses
+func (sa *SelfAddress) IsLocal(ip net.IP) bool {
+	return slices.ContainsFunc(sa.Addrs, func(a InterfaceAddr) bool { return a.IP.Equal(ip) })
+}
+
+// boolOrder sorts false before true
+func boolOrder(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	default:
+		return -1
+	}
+}
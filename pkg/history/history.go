@@ -0,0 +1,242 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/eglochon/simple-lan-messaging/models"
+	"google.golang.org/protobuf/proto"
+)
+
+// Direction tells whether a message was received or sent
+type Direction string
+
+const (
+	Inbound  Direction = "in"
+	Outbound Direction = "out"
+)
+
+// Record is one stored envelope
+type Record struct {
+	Time      time.Time `json:"time"`
+	PeerID    string    `json:"peer,omitempty"` // sender of inbound, recipient of outbound direct messages
+	Topic     string    `json:"topic,omitempty"`
+	Direction Direction `json:"dir"`
+	Type      string    `json:"type"`
+	Envelope  []byte    `json:"envelope"` // marshalled models.Envelope
+}
+
+// Conversation returns the key a record is filed under: "#topic" for topic
+// messages, the peer ID for direct messages
+func (r Record) Conversation() string {
+	return ConversationKey(r.PeerID, r.Topic)
+}
+
+// ConversationKey builds the conversation key for a peer or a topic
+func ConversationKey(peerID, topic string) string {
+	if topic != "" {
+		return "#" + topic
+	}
+	return peerID
+}
+
+// Decode unmarshals the stored envelope
+func (r Record) Decode() (*models.Envelope, error) {
+	var env models.Envelope
+	if err := proto.Unmarshal(r.Envelope, &env); err != nil {
+		return nil, err
+	}
+	return &env, nil
+}
+
+// span locates one JSON line in the log file
+type span struct {
+	offset int64
+	length int
+}
+
+// Store is an append-only JSON lines log of messages with an in-memory index
+type Store struct {
+	mu    sync.Mutex
+	file  *os.File
+	size  int64
+	index map[string][]span // conversation → records, oldest first
+}
+
+// Open opens or creates the history log at path and indexes its records
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		file:  file,
+		index: make(map[string][]span),
+	}
+	if err := s.load(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read history %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// DefaultPath returns the history location in the working directory
+func DefaultPath() string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "history.jsonl"
+	}
+	return filepath.Join(cwd, "history.jsonl")
+}
+
+// load indexes every complete record. Lines that don't decode are skipped
+// and reported, only an unterminated last line, left by a write that was cut
+// short, is removed from the file.
+func (s *Store) load() error {
+	reader := bufio.NewReader(s.file)
+	var offset int64
+	var corrupt int
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		var rec Record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			corrupt++
+		} else {
+			key := rec.Conversation()
+			s.index[key] = append(s.index[key], span{offset: offset, length: len(line)})
+		}
+		offset += int64(len(line))
+	}
+	if corrupt > 0 {
+		log.Printf("[WARN] Skipped %d unreadable history record(s) in %s", corrupt, s.file.Name())
+	}
+
+	s.size = offset
+	if err := s.file.Truncate(offset); err != nil {
+		return err
+	}
+	_, err := s.file.Seek(offset, io.SeekStart)
+	return err
+}
+
+// Add stores env with its peer, topic, direction and the current time
+func (s *Store) Add(dir Direction, peerID string, env *models.Envelope) error {
+	data, err := proto.Marshal(env)
+	if err != nil {
+		return err
+	}
+	return s.Append(Record{
+		Time:      time.Now(),
+		PeerID:    peerID,
+		Topic:     env.GetMessage().GetTopic(),
+		Direction: dir,
+		Type:      env.GetType(),
+		Envelope:  data,
+	})
+}
+
+// Append writes a record to the end of the log
+func (s *Store) Append(rec Record) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return errors.New("history store closed")
+	}
+	if _, err := s.file.WriteAt(line, s.size); err != nil {
+		return err
+	}
+	key := rec.Conversation()
+	s.index[key] = append(s.index[key], span{offset: s.size, length: len(line)})
+	s.size += int64(len(line))
+	return nil
+}
+
+// Page returns up to limit records of a conversation, oldest first, that
+// precede the cursor before. A negative cursor starts from the newest
+// record. The returned cursor fetches the previous page and is 0 once the
+// beginning of the conversation was reached.
+func (s *Store) Page(conversation string, before, limit int) ([]Record, int, error) {
+	if limit <= 0 {
+		return nil, 0, fmt.Errorf("invalid page size %d", limit)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil, 0, errors.New("history store closed")
+	}
+
+	spans := s.index[conversation]
+	if before < 0 || before > len(spans) {
+		before = len(spans)
+	}
+	start := max(before-limit, 0)
+
+	records := make([]Record, 0, before-start)
+	for _, sp := range spans[start:before] {
+		buf := make([]byte, sp.length)
+		if _, err := s.file.ReadAt(buf, sp.offset); err != nil {
+			return nil, 0, err
+		}
+		var rec Record
+		if err := json.Unmarshal(buf, &rec); err != nil {
+			return nil, 0, err
+		}
+		records = append(records, rec)
+	}
+	return records, start, nil
+}
+
+// Conversations returns the keys of all stored conversations
+func (s *Store) Conversations() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, len(s.index))
+	for key := range s.index {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Close flushes and closes the log file
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := errors.Join(s.file.Sync(), s.file.Close())
+	s.file = nil
+	return err
+}
@@ -0,0 +1,137 @@
+package identity
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// PassphraseFunc supplies the passphrase protecting an identity file. When
+// isNew is true the passphrase is being chosen and should be confirmed.
+type PassphraseFunc func(isNew bool) ([]byte, error)
+
+var (
+	ErrPassphraseRequired = errors.New("identity file is encrypted, passphrase required")
+	ErrWrongPassphrase    = errors.New("wrong passphrase or corrupted identity file")
+)
+
+// scrypt cost parameters for newly encrypted files
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// maxScryptMemory bounds the memory a key file may make scrypt use, about
+// 128*N*r*p bytes, so a crafted file can't exhaust it
+const maxScryptMemory = 256 << 20
+
+// keyFileAAD binds the ciphertext to this file format
+var keyFileAAD = []byte("slm-identity-v1")
+
+type encryptedKey struct {
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       string `json:"salt"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"` // XChaCha20-Poly1305 sealed serializedKey
+}
+
+// SaveEncrypted writes the keypair to a JSON file, sealed with a key derived
+// from passphrase using scrypt
+func (id *Identity) SaveEncrypted(path string, passphrase []byte) error {
+	if len(passphrase) == 0 {
+		return errors.New("empty passphrase")
+	}
+
+	plaintext, err := json.Marshal(id.serialize())
+	if err != nil {
+		return err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, chacha20poly1305.KeySize)
+	if err != nil {
+		return err
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	return writeKeyFile(path, encryptedKey{
+		KDF:        "scrypt",
+		N:          scryptN,
+		R:          scryptR,
+		P:          scryptP,
+		Salt:       base64.RawURLEncoding.EncodeToString(salt),
+		Nonce:      base64.RawURLEncoding.EncodeToString(nonce),
+		Ciphertext: base64.RawURLEncoding.EncodeToString(aead.Seal(nil, nonce, plaintext, keyFileAAD)),
+	})
+}
+
+// isEncryptedKeyFile reports whether raw holds an encrypted identity
+func isEncryptedKeyFile(raw []byte) bool {
+	var probe encryptedKey
+	return json.Unmarshal(raw, &probe) == nil && probe.Ciphertext != ""
+}
+
+func decryptKeyFile(raw []byte, passphrase []byte) (serializedKey, error) {
+	var data serializedKey
+	var enc encryptedKey
+	if err := json.Unmarshal(raw, &enc); err != nil {
+		return data, err
+	}
+	if enc.KDF != "scrypt" {
+		return data, fmt.Errorf("unsupported key derivation %q", enc.KDF)
+	}
+	if enc.N <= 1 || enc.R <= 0 || enc.P <= 0 {
+		return data, errors.New("invalid scrypt parameters")
+	}
+	if enc.N > 1<<20 || enc.R > 32 || enc.P > 16 || 128*int64(enc.N)*int64(enc.R)*int64(enc.P) > maxScryptMemory {
+		return data, errors.New("scrypt parameters too expensive")
+	}
+
+	salt, err := base64.RawURLEncoding.DecodeString(enc.Salt)
+	if err != nil {
+		return data, errors.New("invalid salt")
+	}
+	nonce, err := base64.RawURLEncoding.DecodeString(enc.Nonce)
+	if err != nil || len(nonce) != chacha20poly1305.NonceSizeX {
+		return data, errors.New("invalid nonce")
+	}
+	ciphertext, err := base64.RawURLEncoding.DecodeString(enc.Ciphertext)
+	if err != nil {
+		return data, errors.New("invalid ciphertext")
+	}
+
+	key, err := scrypt.Key(passphrase, salt, enc.N, enc.R, enc.P, chacha20poly1305.KeySize)
+	if err != nil {
+		return data, fmt.Errorf("invalid scrypt parameters: %w", err)
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return data, err
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, keyFileAAD)
+	if err != nil {
+		return data, ErrWrongPassphrase
+	}
+
+	err = json.Unmarshal(plaintext, &data)
+	return data, err
+}
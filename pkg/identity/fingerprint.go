@@ -0,0 +1,74 @@
+package identity
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"fmt"
+	"strings"
+)
+
+// fingerprintIterations slows down brute-forcing a key with a colliding fingerprint
+const fingerprintIterations = 5200
+
+// fingerprintVersion is mixed into the hash so the format can change later
+var fingerprintVersion = []byte{0, 0}
+
+// Fingerprint returns the 30-digit numeric fingerprint of a public key
+func Fingerprint(pub ed25519.PublicKey) string {
+	h := sha512.New()
+	h.Write(fingerprintVersion)
+	h.Write(pub)
+	hash := h.Sum(nil)
+
+	for i := 0; i < fingerprintIterations; i++ {
+		h.Reset()
+		h.Write(hash)
+		h.Write(pub)
+		hash = h.Sum(hash[:0])
+	}
+
+	// Six 5-digit blocks, each taken from 5 bytes of the hash
+	var sb strings.Builder
+	for i := 0; i < 30; i += 5 {
+		chunk := uint64(hash[i])<<32 | uint64(hash[i+1])<<24 | uint64(hash[i+2])<<16 |
+			uint64(hash[i+3])<<8 | uint64(hash[i+4])
+		fmt.Fprintf(&sb, "%05d", chunk%100000)
+	}
+	return sb.String()
+}
+
+// SafetyNumber returns the 60-digit number shared by two keys. Both sides
+// compute the same value regardless of argument order.
+func SafetyNumber(a, b ed25519.PublicKey) string {
+	if bytes.Compare(a, b) > 0 {
+		a, b = b, a
+	}
+	return Fingerprint(a) + Fingerprint(b)
+}
+
+// FormatDigits splits a numeric fingerprint into space-separated blocks of five
+func FormatDigits(digits string) string {
+	var blocks []string
+	for len(digits) > 5 {
+		blocks = append(blocks, digits[:5])
+		digits = digits[5:]
+	}
+	blocks = append(blocks, digits)
+	return strings.Join(blocks, " ")
+}
+
+// Fingerprint returns the numeric fingerprint of our signing key
+func (id *Identity) Fingerprint() string {
+	return Fingerprint(id.SigningPublicKey)
+}
+
+// SafetyNumber returns the number to compare out of band with remote
+func (id *Identity) SafetyNumber(remote *RemoteIdentity) string {
+	return SafetyNumber(id.SigningPublicKey, remote.PublicKey)
+}
+
+// Fingerprint returns the numeric fingerprint of the remote signing key
+func (id *RemoteIdentity) Fingerprint() string {
+	return Fingerprint(id.PublicKey)
+}
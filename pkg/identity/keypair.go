@@ -5,6 +5,8 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
 	"os"
 	"path/filepath"
 )
@@ -16,37 +18,66 @@ type serializedKey struct {
 	EncryptPublic  string `json:"encrypt_public"`
 }
 
-// Save writes the keypair to a JSON file
+// Save writes the keypair to a JSON file readable only by the owner
 func (id *Identity) Save(path string) error {
-	data := serializedKey{
+	return writeKeyFile(path, id.serialize())
+}
+
+func (id *Identity) serialize() serializedKey {
+	return serializedKey{
 		SigningPrivate: base64.RawURLEncoding.EncodeToString(id.SigningPrivateKey),
 		SigningPublic:  base64.RawURLEncoding.EncodeToString(id.SigningPublicKey),
 		EncryptPrivate: base64.RawURLEncoding.EncodeToString(id.EncryptPrivateKey[:]),
 		EncryptPublic:  base64.RawURLEncoding.EncodeToString(id.EncryptPublicKey[:]),
 	}
+}
 
-	file, err := os.Create(path)
+// writeKeyFile atomically replaces path with the JSON encoding of v
+func writeKeyFile(path string, v any) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-
-	return json.NewEncoder(file).Encode(data)
+	if err := json.NewEncoder(file).Encode(v); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
 }
 
-// LoadIdentity loads a saved keypair from a file
-func LoadIdentity(path string) (*Identity, error) {
-	file, err := os.Open(path)
+// LoadIdentity loads a saved keypair from a file. Encrypted files call
+// passphrase to unlock them; a nil passphrase only loads plaintext files.
+func LoadIdentity(path string, passphrase PassphraseFunc) (*Identity, error) {
+	raw, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
 
 	var data serializedKey
-	if err := json.NewDecoder(file).Decode(&data); err != nil {
+	if isEncryptedKeyFile(raw) {
+		if passphrase == nil {
+			return nil, ErrPassphraseRequired
+		}
+		pass, err := passphrase(false)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = decryptKeyFile(raw, pass); err != nil {
+			return nil, err
+		}
+	} else if err := json.Unmarshal(raw, &data); err != nil {
 		return nil, err
 	}
+	return data.identity()
+}
 
+func (data serializedKey) identity() (*Identity, error) {
 	// Decode Ed25519 keys
 	signingPriv, err := base64.RawURLEncoding.DecodeString(data.SigningPrivate)
 	if err != nil || len(signingPriv) != ed25519.PrivateKeySize {
@@ -80,14 +111,24 @@ func LoadIdentity(path string) (*Identity, error) {
 	}, nil
 }
 
-// GetOrCreate tries to load an identity from the given path,
-// or generates and saves a new one if not found or invalid.
-func GetOrCreateIdentity(path string) (*Identity, error) {
+// GetOrCreate tries to load an identity from the given path, or generates
+// and saves a new one if the file doesn't exist. With a non-nil passphrase
+// new identities are saved encrypted and plaintext files are migrated.
+func GetOrCreateIdentity(path string, passphrase PassphraseFunc) (*Identity, error) {
 	// Try to load
-	id, err := LoadIdentity(path)
+	id, err := LoadIdentity(path, passphrase)
 	if err == nil {
+		if passphrase != nil {
+			if err := migrateKeyFile(path, id, passphrase); err != nil {
+				return nil, fmt.Errorf("failed to encrypt identity file: %w", err)
+			}
+		}
 		return id, nil
 	}
+	if !errors.Is(err, os.ErrNotExist) {
+		// Never overwrite a file we couldn't read, it may just be locked
+		return nil, err
+	}
 
 	// Generate new
 	id, err = NewIdentity()
@@ -101,13 +142,40 @@ func GetOrCreateIdentity(path string) (*Identity, error) {
 	}
 
 	// Save to path
-	if err := id.Save(path); err != nil {
+	if passphrase != nil {
+		pass, err := passphrase(true)
+		if err != nil {
+			return nil, err
+		}
+		err = id.SaveEncrypted(path, pass)
+	} else {
+		err = id.Save(path)
+	}
+	if err != nil {
 		return nil, err
 	}
 
 	return id, nil
 }
 
+// migrateKeyFile rewrites a plaintext identity file in encrypted form
+func migrateKeyFile(path string, id *Identity, passphrase PassphraseFunc) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if isEncryptedKeyFile(raw) {
+		return nil
+	}
+
+	log.Printf("[IDENTITY] Encrypting plaintext identity file %s", path)
+	pass, err := passphrase(true)
+	if err != nil {
+		return err
+	}
+	return id.SaveEncrypted(path, pass)
+}
+
 func DefaultPath() string {
 	cwd, err := os.Getwd()
 	if err != nil {
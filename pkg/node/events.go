@@ -0,0 +1,73 @@
+package node
+
+import (
+	"log"
+
+	"github.com/eglochon/simple-lan-messaging/models"
+	"github.com/eglochon/simple-lan-messaging/pkg/comms"
+)
+
+// Message is an envelope received from a peer
+type Message struct {
+	PeerID   string
+	Envelope *models.Envelope
+}
+
+// EventType tells what an Event is about
+type EventType string
+
+const (
+	EventPeerJoined EventType = "peer-joined" // a peer came online
+	EventPeerLeft   EventType = "peer-left"   // a peer went offline
+	EventConnState  EventType = "conn-state"  // the connection to a peer changed, see State
+	EventReceipt    EventType = "receipt"     // a peer received or read our messages, see Receipt
+	EventSecurity   EventType = "security"    // a peer was accepted despite a suspicious identity, see Err
+)
+
+// Event reports a change concerning a peer
+type Event struct {
+	Type    EventType
+	PeerID  string
+	State   comms.ConnState // set for EventConnState
+	Receipt *models.Receipt // set for EventReceipt
+	Err     error           // set for EventSecurity
+}
+
+// Messages returns the channel of received messages that no topic
+// subscription handled. Reading from it is required: a full channel holds up
+// the connection the next message arrives on. It is closed by Stop.
+func (n *Node) Messages() <-chan Message {
+	return n.messages
+}
+
+// Events returns the channel of peer and connection events. Events are
+// dropped while the channel is full. It is closed by Stop.
+func (n *Node) Events() <-chan Event {
+	return n.events
+}
+
+// deliverMessage waits until the application takes the message or the node stops
+func (n *Node) deliverMessage(peerID string, env *models.Envelope) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.closed {
+		return
+	}
+	select {
+	case n.messages <- Message{PeerID: peerID, Envelope: env}:
+	case <-n.done:
+	}
+}
+
+func (n *Node) emit(event Event) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.closed {
+		return
+	}
+	select {
+	case n.events <- event:
+	default:
+		log.Printf("[NODE] Event queue full, dropping %s event for %s", event.Type, event.PeerID)
+	}
+}
@@ -0,0 +1,373 @@
+// Package node bundles identity, discovery, the TCP receiver and the peer
+// manager into one embeddable LAN messaging node.
+package node
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eglochon/simple-lan-messaging/models"
+	"github.com/eglochon/simple-lan-messaging/pkg/comms"
+	"github.com/eglochon/simple-lan-messaging/pkg/contacts"
+	"github.com/eglochon/simple-lan-messaging/pkg/discovery"
+	"github.com/eglochon/simple-lan-messaging/pkg/history"
+	"github.com/eglochon/simple-lan-messaging/pkg/identity"
+	"google.golang.org/protobuf/proto"
+)
+
+// Defaults for zero Options fields
+const (
+	DefaultPort             = 40480
+	DefaultAnnounceInterval = 3 * time.Second
+	DefaultOutboxTTL        = 7 * 24 * time.Hour
+	DefaultEventBuffer      = 64
+)
+
+var (
+	ErrStopped = errors.New("node stopped")
+	ErrStarted = errors.New("node already started")
+)
+
+// Options configures a Node. Zero values select the defaults and nil
+// policies the comms defaults. A policy that is set is used as is, so its
+// zero fields disable what they control, such as a zero keepalive interval.
+type Options struct {
+	// Identity is used as is when set. Otherwise it is loaded from
+	// IdentityPath, or created there, using Passphrase for encrypted files.
+	Identity     *identity.Identity
+	IdentityPath string // default identity.DefaultPath()
+	Passphrase   identity.PassphraseFunc
+
+	Name             string        // announced display name, default the hostname
+	Port             uint16        // TCP service port, default DefaultPort
+	AnnounceInterval time.Duration // time between discovery announcements
+	Group            string        // discovery multicast group, default discovery.DefaultGroup
+	Interface        string        // only use this network interface, default every eligible one
+	Bind             string        // only use this local IPv4 address
+	MaxMessageSize   int           // largest message sent or reassembled
+
+	Contacts  *contacts.Store // optional key pinning and blocking
+	Outbox    *comms.Outbox   // optional queue for messages to offline peers
+	OutboxTTL time.Duration   // how long queued messages are kept
+	History   *history.Store  // optional log of every message sent and received
+
+	Rekey     *comms.RekeyPolicy
+	Keepalive *comms.KeepalivePolicy
+	Reconnect *comms.ReconnectPolicy
+	Presence  *comms.PresencePolicy
+
+	EventBuffer int // capacity of the Messages and Events channels
+}
+
+// Node is a LAN messaging node: it announces itself, discovers peers and
+// exchanges encrypted messages with them.
+type Node struct {
+	opts Options
+	id   *identity.Identity
+	self *discovery.SelfAddress
+	pm   *comms.PeerManager
+
+	receivers []*comms.TCPReceiver // one per local address
+	discovery *discovery.DiscoveryService
+
+	started  atomic.Bool
+	messages chan Message
+	events   chan Event
+	mu       sync.RWMutex // guards closed against sends on the channels
+	closed   bool
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// New loads the identity and prepares the node; nothing touches the network
+// before Start.
+func New(opts Options) (*Node, error) {
+	self, err := discovery.NewSelfAddress(opts.Interface, opts.Bind)
+	if err != nil {
+		return nil, fmt.Errorf("local address: %w", err)
+	}
+
+	id := opts.Identity
+	if id == nil {
+		if opts.IdentityPath == "" {
+			opts.IdentityPath = identity.DefaultPath()
+		}
+		id, err = identity.GetOrCreateIdentity(opts.IdentityPath, opts.Passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("identity: %w", err)
+		}
+	}
+
+	if opts.Name == "" {
+		opts.Name = self.Hostname
+	}
+	if opts.Port == 0 {
+		opts.Port = DefaultPort
+	}
+	if opts.AnnounceInterval <= 0 {
+		opts.AnnounceInterval = DefaultAnnounceInterval
+	}
+	if opts.MaxMessageSize <= 0 {
+		opts.MaxMessageSize = comms.DefaultMaxMessageSize
+	}
+	if opts.OutboxTTL <= 0 {
+		opts.OutboxTTL = DefaultOutboxTTL
+	}
+	if opts.EventBuffer <= 0 {
+		opts.EventBuffer = DefaultEventBuffer
+	}
+
+	pm := comms.NewPeerManager(id)
+	pm.SetMaxMessageSize(opts.MaxMessageSize)
+	if opts.Contacts != nil {
+		pm.UseContacts(opts.Contacts)
+	}
+	if opts.Outbox != nil {
+		pm.UseOutbox(opts.Outbox)
+	}
+	if opts.History != nil {
+		pm.UseHistory(opts.History)
+	}
+	if opts.Rekey != nil {
+		pm.SetRekeyPolicy(*opts.Rekey)
+	}
+	if opts.Keepalive != nil {
+		pm.SetKeepalivePolicy(*opts.Keepalive)
+	}
+	if opts.Reconnect != nil {
+		pm.SetReconnectPolicy(*opts.Reconnect)
+	}
+	if opts.Presence == nil {
+		presence := comms.DefaultPresencePolicy
+		opts.Presence = &presence
+	}
+
+	n := &Node{
+		opts:     opts,
+		id:       id,
+		self:     self,
+		pm:       pm,
+		messages: make(chan Message, opts.EventBuffer),
+		events:   make(chan Event, opts.EventBuffer),
+		done:     make(chan struct{}),
+	}
+	pm.OnMessage(n.deliverMessage)
+	pm.OnReceipt(func(peerID string, receipt *models.Receipt) {
+		n.emit(Event{Type: EventReceipt, PeerID: peerID, Receipt: receipt})
+	})
+	pm.OnPeerJoined(func(peerID string) {
+		n.emit(Event{Type: EventPeerJoined, PeerID: peerID})
+	})
+	pm.OnPeerLeft(func(peerID string) {
+		n.emit(Event{Type: EventPeerLeft, PeerID: peerID})
+	})
+	pm.OnConnState(func(peerID string, state comms.ConnState) {
+		n.emit(Event{Type: EventConnState, PeerID: peerID, State: state})
+	})
+	pm.OnSecurityWarning(func(peerID string, err error) {
+		n.emit(Event{Type: EventSecurity, PeerID: peerID, Err: err})
+	})
+	return n, nil
+}
+
+// Start accepts connections, tracks presence and begins announcing the node.
+// Cancelling ctx shuts the node down; Stop then waits for it to finish. A
+// node starts once: a failed Start stops it again, as Stop does.
+func (n *Node) Start(ctx context.Context) error {
+	if !n.started.CompareAndSwap(false, true) {
+		return ErrStarted
+	}
+	if err := n.pm.Start(ctx); err != nil {
+		return ErrStopped
+	}
+	if err := n.start(ctx); err != nil {
+		n.Stop()
+		return err
+	}
+	return nil
+}
+
+// start brings up the receivers and discovery, leaving what it started for
+// Stop to close
+func (n *Node) start(ctx context.Context) error {
+	n.pm.TrackPresence(*n.opts.Presence)
+
+	// Peers connect to the address they heard us announce from, which is
+	// any of ours on a host with several networks
+	for _, addr := range n.self.Addrs {
+		receiver := comms.NewTCPReceiver(net.JoinHostPort(addr.IP.String(), strconv.Itoa(int(n.opts.Port))), n.id, n.pm)
+		if err := receiver.Start(ctx); err != nil {
+			return err
+		}
+		n.receivers = append(n.receivers, receiver)
+	}
+
+	ds := discovery.NewDiscoveryService(n.self, discovery.NewAnnouncer(n.announcement, n.id), n.opts.AnnounceInterval, n.handleAnnouncement)
+	ds.Group = n.opts.Group
+	if err := ds.Start(ctx); err != nil {
+		return fmt.Errorf("discovery: %w", err)
+	}
+	n.discovery = ds
+	return nil
+}
+
+// Stop shuts the node down, waits for its goroutines and closes the
+// Messages and Events channels.
+func (n *Node) Stop() error {
+	var err error
+	n.stopOnce.Do(func() {
+		close(n.done)
+
+		var errs []error
+		if n.discovery != nil {
+			errs = append(errs, n.discovery.Close())
+		}
+		for _, receiver := range n.receivers {
+			errs = append(errs, receiver.Close())
+		}
+		errs = append(errs, n.pm.Close())
+
+		n.mu.Lock()
+		n.closed = true
+		close(n.messages)
+		close(n.events)
+		n.mu.Unlock()
+
+		err = errors.Join(errs...)
+	})
+	return err
+}
+
+// ID returns the node's public ID
+func (n *Node) ID() string {
+	return n.id.GetID()
+}
+
+// Name returns the announced display name
+func (n *Node) Name() string {
+	return n.opts.Name
+}
+
+// Identity returns the node's key pair
+func (n *Node) Identity() *identity.Identity {
+	return n.id
+}
+
+// SelfAddress returns the local addresses the node listens and announces on
+func (n *Node) SelfAddress() *discovery.SelfAddress {
+	return n.self
+}
+
+// PeerManager gives access to everything the Node doesn't wrap, such as
+// file transfers, supervised peers and outbox notifications
+func (n *Node) PeerManager() *comms.PeerManager {
+	return n.pm
+}
+
+// Peers returns all known peers except ourselves, ordered by ID
+func (n *Node) Peers() []comms.PeerInfo {
+	var peers []comms.PeerInfo
+	for _, peer := range n.pm.AllPeers() {
+		if peer.ID != n.ID() {
+			peers = append(peers, peer)
+		}
+	}
+	slices.SortFunc(peers, func(a, b comms.PeerInfo) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	return peers
+}
+
+// Send sends a direct message to the peer with the given ID, name or ID
+// prefix and returns the message ID. With an outbox, messages to offline
+// peers are queued instead of failing.
+func (n *Node) Send(peer, content string) (string, error) {
+	target, err := n.pm.Lookup(peer)
+	if err != nil {
+		return "", err
+	}
+	env := &models.Envelope{
+		Type: "message",
+		Payload: &models.Envelope_Message{
+			Message: &models.TopicMessage{Content: content},
+		},
+	}
+	if n.opts.Outbox != nil {
+		id, _, err := n.pm.SendOrQueue(target.ID, env, n.opts.OutboxTTL)
+		return id, err
+	}
+	if err := n.pm.Send(target.ID, env); err != nil {
+		return "", err
+	}
+	return env.GetId(), nil
+}
+
+// SendEnvelope sends a prepared envelope to peerID
+func (n *Node) SendEnvelope(peerID string, env *models.Envelope) error {
+	return n.pm.Send(peerID, env)
+}
+
+// Publish sends content to every peer subscribed to topic and returns the message ID
+func (n *Node) Publish(topic, content string) (string, error) {
+	return n.pm.Publish(topic, content)
+}
+
+// Subscribe calls handler for messages on topics matching pattern. Messages
+// without a matching subscription, or matching one with a nil handler, arrive
+// on Messages instead.
+func (n *Node) Subscribe(pattern string, handler comms.TopicHandler) error {
+	return n.pm.Subscribe(pattern, handler)
+}
+
+// Unsubscribe removes the handlers for pattern
+func (n *Node) Unsubscribe(pattern string) {
+	n.pm.Unsubscribe(pattern)
+}
+
+// announcement is the discovery message the node broadcasts. It carries our
+// subscriptions, so peers know where to publish without connecting first.
+func (n *Node) announcement() *models.Discovery {
+	return &models.Discovery{
+		Id:     n.id.GetID(),
+		Enc:    base64.RawURLEncoding.EncodeToString(n.id.EncryptPublicKey[:]),
+		Name:   n.opts.Name,
+		Ip:     n.self.IP,
+		Port:   uint32(n.opts.Port),
+		Topics: n.pm.Subscriptions(),
+		Addrs:  n.addrs(),
+	}
+}
+
+// addrs lists every address the node listens on
+func (n *Node) addrs() []string {
+	addrs := make([]string, len(n.self.Addrs))
+	for i, addr := range n.self.Addrs {
+		addrs[i] = addr.IP.String()
+	}
+	return addrs
+}
+
+// handleAnnouncement registers peers from discovery announcements
+func (n *Node) handleAnnouncement(data []byte, addr *net.UDPAddr) {
+	var msg models.Discovery
+	if err := proto.Unmarshal(data, &msg); err != nil {
+		log.Printf("[DISCOVERY] Invalid announcement from %s: %v", addr.IP, err)
+		return
+	}
+	// Peers sharing several networks with us send each announcement on all
+	// of them, the copies are expected
+	err := n.pm.RegisterDiscovery(&msg, addr)
+	if err != nil && !errors.Is(err, discovery.ErrReplayedAnnouncement) {
+		log.Printf("[DISCOVERY] Ignored announcement from %s: %v", addr.IP, err)
+	}
+}